@@ -1,32 +1,104 @@
 package main
 
 import (
+	"context"
+	"expvar"
+	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"tgpt/internal/chat"
+	"tgpt/internal/stt"
 	"tgpt/internal/telegram"
 	pkgHttp "tgpt/pkg/http"
 )
 
+const (
+	updatesModeWebhook = "webhook"
+	updatesModePolling = "polling"
+)
+
+// Values of TELEGRAM_MIGRATE.
+const (
+	migrateLogOut = "logout"
+	migrateClose  = "close"
+)
+
+const (
+	defaultPollTimeout  = 30 * time.Second
+	defaultWorkers      = 4
+	defaultQueueSize    = 100
+	defaultDedupTTL     = 24 * time.Hour
+	shutdownTimeout     = 10 * time.Second
+	defaultDrainTimeout = 2 * time.Minute
+	defaultSTTModel     = "whisper-1"
+	sttTimeout          = 2 * time.Minute
+)
+
 func main() {
 	var (
-		port             = os.Getenv("HTTP_PORT")
+		port = os.Getenv("HTTP_PORT")
+		// debugAddr is the internal address /debug/vars is served
+		// on, like 127.0.0.1:6060. Empty disables it.
+		debugAddr        = os.Getenv("DEBUG_ADDR")
 		token            = os.Getenv("TELEGRAM_BOT_TOKEN")
 		chatID           = os.Getenv("TELEGRAM_CHAT_ID")
 		secretToken      = os.Getenv("TELEGRAM_SECRET_TOKEN")
 		userWhiteListRaw = os.Getenv("TELEGRAM_USER_WHITE_LIST")
+		updatesMode      = os.Getenv("TELEGRAM_UPDATES_MODE")
+		offsetFile       = os.Getenv("TELEGRAM_OFFSET_FILE")
+		dedupFile        = os.Getenv("TELEGRAM_DEDUP_FILE")
+		webhookURL       = os.Getenv("TELEGRAM_WEBHOOK_URL")
+		webhookCert      = os.Getenv("TELEGRAM_WEBHOOK_CERTIFICATE")
+		allowedUpdates   = os.Getenv("TELEGRAM_ALLOWED_UPDATES")
+		dropPending      = os.Getenv("TELEGRAM_DROP_PENDING_UPDATES")
 		qdrantAddr       = os.Getenv("QDRANT_ADDR")
+		apiURL           = os.Getenv("TELEGRAM_API_URL")
+		migrate          = os.Getenv("TELEGRAM_MIGRATE")
+
+		pollTimeout    = time.Duration(intFromEnv("TELEGRAM_POLL_TIMEOUT", int(defaultPollTimeout.Seconds()))) * time.Second
+		maxConnections = intFromEnv("TELEGRAM_WEBHOOK_MAX_CONNECTIONS", 0)
+		workers        = intFromEnv("TELEGRAM_WORKERS", defaultWorkers)
+		queueSize      = intFromEnv("TELEGRAM_QUEUE_SIZE", defaultQueueSize)
+		dedupTTL       = time.Duration(intFromEnv("TELEGRAM_DEDUP_TTL", int(defaultDedupTTL.Seconds()))) * time.Second
+		// drainTimeout bounds how long queued updates are still processed on
+		// shutdown. Their offsets are already committed, so whatever is left
+		// after it is lost; keep it below the container stop grace period.
+		drainTimeout = time.Duration(intFromEnv("TELEGRAM_DRAIN_TIMEOUT", int(defaultDrainTimeout.Seconds()))) * time.Second
 
-		modelType = os.Getenv("MODEL_TYPE")
+		modelType   = os.Getenv("MODEL_TYPE")
+		modelName   = os.Getenv("MODEL_NAME")
+		visionModel = os.Getenv("VISION_MODEL")
+
+		chunkSize    = intFromEnv("CHUNK_SIZE", 0)
+		chunkOverlap = intFromEnv("CHUNK_OVERLAP", 0)
+		// maxDocumentSize caps the size of processed documents, in MB,
+		// zero means the default.
+		maxDocumentSize = intFromEnv("MAX_DOCUMENT_SIZE_MB", 0)
 
 		ollamaAddr = os.Getenv("OLLAMA_ADDR")
 		chatGPTKey = os.Getenv("CHAT_GPT_KEY")
+
+		sttAddr   = os.Getenv("STT_ADDR")
+		sttModel  = os.Getenv("STT_MODEL")
+		sttAPIKey = os.Getenv("STT_API_KEY")
 	)
 
+	if updatesMode == "" {
+		updatesMode = updatesModeWebhook
+	}
+	if updatesMode != updatesModeWebhook && updatesMode != updatesModePolling {
+		slog.Error("unknown TELEGRAM_UPDATES_MODE", "mode", updatesMode)
+		os.Exit(1)
+	}
+
 	if token == "" {
 		slog.Error("token is empty")
 		os.Exit(1)
@@ -35,51 +107,219 @@ func main() {
 		slog.Error("chatID is empty")
 		os.Exit(1)
 	}
-	if port == "" {
-		slog.Error("PORT is empty")
-		os.Exit(1)
-	}
+	if updatesMode == updatesModeWebhook {
+		if port == "" {
+			slog.Error("PORT is empty")
+			os.Exit(1)
+		}
 
-	if secretToken == "" {
-		slog.Error("SECRET_TOKEN is empty")
-		os.Exit(1)
+		if secretToken == "" {
+			slog.Error("SECRET_TOKEN is empty")
+			os.Exit(1)
+		}
 	}
 
 	if userWhiteListRaw == "" || len(strings.Split(userWhiteListRaw, ",")) == 0 {
 		slog.Error("WHITE_LIST is empty")
 		os.Exit(1)
 	}
+	var whiteList []int64
+	for _, raw := range strings.Split(userWhiteListRaw, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if err != nil {
+			slog.Error("WHITE_LIST must contain numeric user and chat ids", "value", raw)
+			os.Exit(1)
+		}
+		whiteList = append(whiteList, id)
+	}
 	if qdrantAddr == "" {
 		slog.Error("QDRANT_ADDR is empty")
 		os.Exit(1)
 	}
+	if maxConnections > 100 {
+		slog.Error("TELEGRAM_WEBHOOK_MAX_CONNECTIONS must be between 1 and 100")
+		os.Exit(1)
+	}
+
+	webhookCfg := telegram.WebhookConfig{
+		URL:                webhookURL,
+		SecretToken:        secretToken,
+		MaxConnections:     maxConnections,
+		CertificatePath:    webhookCert,
+		DropPendingUpdates: dropPending == "true",
+	}
+	webhookCfg.AllowedUpdates = telegram.ParseAllowedUpdates(allowedUpdates)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	httpClient := pkgHttp.NewHttpClient()
 
+	if migrate != "" {
+		err := migrateBot(ctx, httpClient, apiURL, token, migrate)
+		if err != nil {
+			slog.Error("failed to migrate bot", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("bot is ready to be moved to another Bot API server", "migrate", migrate)
+		return
+	}
+
+	// OpenAI picks its own default model when the name is empty.
+	if modelName == "" && modelType != "openai" {
+		modelName = chat.ModelLlama2uncensored
+	}
+
 	c, err := chat.NewService(chat.Config{
-		ModelType:  modelType,
-		ModelName:  chat.ModelLlama2uncensored,
-		OllamaAddr: ollamaAddr,
-		KeepAlive:  "1m",
-		QdrantAddr: qdrantAddr,
-		ChatGPTKey: chatGPTKey,
+		ModelType:       modelType,
+		ModelName:       modelName,
+		VisionModelName: visionModel,
+		OllamaAddr:      ollamaAddr,
+		KeepAlive:       "1m",
+		QdrantAddr:      qdrantAddr,
+		ChatGPTKey:      chatGPTKey,
+		ChunkSize:       chunkSize,
+		ChunkOverlap:    chunkOverlap,
 	})
 	if err != nil {
 		slog.Error("failed to create chat service", "error", err)
 		os.Exit(1)
 	}
-	b := telegram.NewBot(httpClient, token)
-	h := telegram.NewHandler(c, b, secretToken, strings.Split(userWhiteListRaw, ","))
+	b := telegram.NewBot(httpClient, apiURL, token)
+	me, err := b.GetMe(ctx)
+	if err != nil {
+		slog.Error("failed to get bot user", "error", err)
+		os.Exit(1)
+	}
+	var transcriber stt.Transcriber
+	if sttAddr != "" {
+		if sttModel == "" {
+			sttModel = defaultSTTModel
+		}
+		transcriber = stt.NewOpenAI(pkgHttp.NewHttpClientWithTimeout(sttTimeout), sttAddr, sttAPIKey, sttModel)
+	}
+	h := telegram.NewHandler(c, b, transcriber, me, whiteList, int64(maxDocumentSize)<<20)
+	err = h.PublishCommands(ctx)
+	if err != nil {
+		slog.Error("failed to publish commands", "error", err)
+		os.Exit(1)
+	}
 
-	router := http.NewServeMux()
-	router.HandleFunc("/webhook", h.HandleMessage)
+	d := telegram.NewDispatcher(h, workers, queueSize)
+	d.Start()
 
-	srv := NewServer(port, router)
-	err = srv.ListenAndServe()
+	dd, err := telegram.NewDeduplicator(d, dedupTTL, dedupFile)
 	if err != nil {
-		slog.Error("cant start server", "error", err)
+		slog.Error("failed to create deduplicator", "error", err)
+		os.Exit(1)
+	}
+
+	router := http.NewServeMux()
+
+	errCh := make(chan error, 3)
+
+	// Metrics are served apart from the webhook, which is public.
+	var debugSrv *http.Server
+	if debugAddr != "" {
+		debugRouter := http.NewServeMux()
+		debugRouter.Handle("/debug/vars", expvar.Handler())
+		debugSrv = &http.Server{Addr: debugAddr, Handler: debugRouter}
+		go func() {
+			errCh <- debugSrv.ListenAndServe()
+		}()
+	}
+	pollerDone := make(chan struct{})
+
+	switch updatesMode {
+	case updatesModePolling:
+		// getUpdates is rejected while a webhook is set.
+		err = b.DeleteWebhook(ctx, webhookCfg.DropPendingUpdates)
+		if err != nil {
+			slog.Error("delete webhook", "error", err)
+			os.Exit(1)
+		}
+
+		var offsets telegram.OffsetStore
+		if offsetFile != "" {
+			offsets = telegram.NewFileOffsetStore(offsetFile)
+		} else {
+			slog.Warn("TELEGRAM_OFFSET_FILE is empty, offset will not survive restarts")
+		}
+
+		// getUpdates holds the connection for up to pollTimeout,
+		// so it needs a client that waits longer than that.
+		pollingBot := telegram.NewBot(
+			pkgHttp.NewHttpClientWithTimeout(pollTimeout+10*time.Second),
+			apiURL,
+			token,
+		)
+		p := telegram.NewPoller(pollingBot, dd, offsets, pollTimeout)
+		go func() {
+			defer close(pollerDone)
+			if err := p.Run(ctx); err != nil {
+				errCh <- err
+			}
+		}()
+	case updatesModeWebhook:
+		close(pollerDone)
+		router.Handle("/webhook", telegram.NewWebhookHandler(secretToken, dd))
+	}
+
+	var srv *http.Server
+	if port != "" {
+		srv = NewServer(port, router)
+		go func() {
+			errCh <- srv.ListenAndServe()
+		}()
+	}
+
+	if updatesMode == updatesModeWebhook {
+		if webhookCfg.URL != "" {
+			err = telegram.RegisterWebhook(ctx, b, webhookCfg)
+			if err != nil {
+				slog.Error("register webhook", "error", err)
+				os.Exit(1)
+			}
+		} else {
+			slog.Warn("TELEGRAM_WEBHOOK_URL is empty, webhook must be registered manually")
+		}
+	}
+
+	select {
+	case err = <-errCh:
+		slog.Error("cant receive updates", "error", err)
 		os.Exit(1)
+	case <-ctx.Done():
 	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if updatesMode == updatesModeWebhook && webhookCfg.URL != "" {
+		err = b.DeleteWebhook(shutdownCtx, false)
+		if err != nil {
+			slog.Error("delete webhook", "error", err)
+		}
+	}
+
+	if srv != nil {
+		err = srv.Shutdown(shutdownCtx)
+		if err != nil {
+			slog.Error("shutdown server", "error", err)
+		}
+	}
+	if debugSrv != nil {
+		err = debugSrv.Shutdown(shutdownCtx)
+		if err != nil {
+			slog.Error("shutdown debug server", "error", err)
+		}
+	}
+
+	// The dispatcher must not be closed while the poller may still enqueue.
+	<-pollerDone
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancelDrain()
+	d.Close(drainCtx)
 }
 
 func NewServer(port string, h http.Handler) *http.Server {
@@ -92,3 +332,34 @@ func NewServer(port string, h http.Handler) *http.Server {
 
 	return srv
 }
+
+// migrateBot prepares the bot to be moved to another Bot API server:
+// logout logs it out of the cloud server before the first start on a
+// local one, close closes it on the local server at apiURL before
+// moving to another local server. Delete the webhook before closing.
+func migrateBot(ctx context.Context, cli *http.Client, apiURL, token, mode string) error {
+	switch mode {
+	case migrateLogOut:
+		return telegram.NewBot(cli, telegram.DefaultAPIURL, token).LogOut(ctx)
+	case migrateClose:
+		return telegram.NewBot(cli, apiURL, token).Close(ctx)
+	default:
+		return fmt.Errorf("unknown TELEGRAM_MIGRATE %q, use %s or %s", mode, migrateLogOut, migrateClose)
+	}
+}
+
+// intFromEnv returns def if the variable is not set and
+// exits if it is set to anything but a non-negative integer.
+func intFromEnv(name string, def int) int {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < 0 {
+		slog.Error("invalid "+name, "value", raw)
+		os.Exit(1)
+	}
+	return v
+}
@@ -0,0 +1,123 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/tmc/langchaingo/documentloaders"
+	"github.com/tmc/langchaingo/schema"
+	"github.com/tmc/langchaingo/textsplitter"
+
+	"tgpt/internal/models"
+)
+
+const (
+	defaultChunkSize    = 1000
+	defaultChunkOverlap = 100
+)
+
+// ErrUnsupportedFile is returned by SaveFile for files it can't extract text from.
+var ErrUnsupportedFile = errors.New("unsupported file type")
+
+// File is an uploaded document. Content is read through a ReaderAt,
+// so large files can be kept on disk instead of in memory.
+type File struct {
+	Name     string
+	MimeType string
+	Content  io.ReaderAt
+	Size     int64
+}
+
+// SaveFile extracts the text of the file, splits it into chunks and
+// stores them as documents of the message. It returns the number of
+// stored chunks.
+func (s *Service) SaveFile(ctx context.Context, message models.Message, file File) (int, error) {
+	reportProgress(ctx, StageSaving)
+
+	loader, splitter, err := s.fileLoader(file)
+	if err != nil {
+		return 0, err
+	}
+
+	chunks, err := loader.LoadAndSplit(ctx, splitter)
+	if err != nil {
+		return 0, fmt.Errorf("load %s: %w", file.Name, err)
+	}
+
+	docs := make([]schema.Document, 0, len(chunks))
+	for i, chunk := range chunks {
+		content := strings.TrimSpace(chunk.PageContent)
+		if content == "" {
+			continue
+		}
+
+		metaData := documentMetadata(message)
+		metaData["file_name"] = file.Name
+		metaData["chunk"] = i
+		docs = append(docs, schema.Document{
+			PageContent: author(message) + " [" + file.Name + "]: " + content,
+			Metadata:    metaData,
+		})
+	}
+	if len(docs) == 0 {
+		return 0, nil
+	}
+
+	err = s.addDocuments(ctx, message, docs)
+	if err != nil {
+		return 0, err
+	}
+	return len(docs), nil
+}
+
+func (s *Service) fileLoader(file File) (documentloaders.Loader, textsplitter.TextSplitter, error) {
+	opts := []textsplitter.Option{
+		textsplitter.WithChunkSize(s.chunkSize),
+		textsplitter.WithChunkOverlap(s.chunkOverlap),
+	}
+	r := io.NewSectionReader(file.Content, 0, file.Size)
+
+	switch fileType(file) {
+	case "txt":
+		return documentloaders.NewText(r), textsplitter.NewRecursiveCharacter(opts...), nil
+	case "md":
+		return documentloaders.NewText(r), textsplitter.NewMarkdownTextSplitter(opts...), nil
+	case "html":
+		return documentloaders.NewHTML(r), textsplitter.NewRecursiveCharacter(opts...), nil
+	case "pdf":
+		return documentloaders.NewPDF(r, file.Size), textsplitter.NewRecursiveCharacter(opts...), nil
+	default:
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnsupportedFile, file.Name)
+	}
+}
+
+// fileType detects the file type by its extension, then by its mime type.
+func fileType(file File) string {
+	switch strings.ToLower(path.Ext(file.Name)) {
+	case ".txt", ".text", ".log":
+		return "txt"
+	case ".md", ".markdown":
+		return "md"
+	case ".html", ".htm":
+		return "html"
+	case ".pdf":
+		return "pdf"
+	}
+
+	switch mimeType, _, _ := strings.Cut(file.MimeType, ";"); mimeType {
+	case "text/plain":
+		return "txt"
+	case "text/markdown":
+		return "md"
+	case "text/html":
+		return "html"
+	case "application/pdf":
+		return "pdf"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,42 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"tgpt/internal/models"
+)
+
+func TestFileType(t *testing.T) {
+	for _, tc := range []struct {
+		file File
+		want string
+	}{
+		{File{Name: "notes.TXT"}, "txt"},
+		{File{Name: "README.md", MimeType: "text/plain"}, "md"},
+		{File{Name: "page.htm"}, "html"},
+		{File{Name: "report.pdf"}, "pdf"},
+		{File{Name: "report", MimeType: "application/pdf"}, "pdf"},
+		{File{Name: "notes", MimeType: "text/plain; charset=utf-8"}, "txt"},
+		{File{Name: "photo.jpg", MimeType: "image/jpeg"}, ""},
+	} {
+		require.Equal(t, tc.want, fileType(tc.file), tc.file.Name)
+	}
+}
+
+func TestRecallFilter(t *testing.T) {
+	user := models.NewUserID(-100, 7)
+
+	f := recallFilter(models.Message{UserName: user, Topic: "#travel"})
+	require.Equal(t, []filterEntry{
+		{Key: "topic", Match: &filterEntryMatch{Value: "#travel"}},
+		{Key: "user_id", Match: &filterEntryMatch{Value: user.String()}},
+	}, f.Must)
+
+	f = recallFilter(models.Message{UserName: user, Topic: "#default", DocumentID: "AgADBQAD"})
+	require.Equal(t, []filterEntry{
+		{Key: "document_id", Match: &filterEntryMatch{Value: "AgADBQAD"}},
+		{Key: "user_id", Match: &filterEntryMatch{Value: user.String()}},
+	}, f.Must)
+}
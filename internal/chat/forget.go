@@ -0,0 +1,74 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"tgpt/internal/models"
+	pkgContext "tgpt/pkg/context"
+)
+
+// Forget selects memories of a user to delete.
+// Zero fields don't restrict the selection, so Forget{} selects all of them.
+type Forget struct {
+	// ChatID and MessageID select the documents of a single message.
+	ChatID    int64
+	MessageID int64
+	Topic     string
+	// Since and Until select documents saved in [Since, Until).
+	Since time.Time
+	Until time.Time
+}
+
+// Forget deletes the selected documents of the user and clears the user's
+// conversation buffer. It returns the number of deleted documents.
+func (s *Service) Forget(ctx context.Context, user models.UserID, f Forget) (int, error) {
+	filters := forgetFilter(user, f)
+
+	n, err := s.points.count(ctx, filters)
+	if err != nil {
+		return 0, fmt.Errorf("count documents: %w", err)
+	}
+	if n > 0 {
+		err = s.points.delete(ctx, filters)
+		if err != nil {
+			return 0, fmt.Errorf("delete documents: %w", err)
+		}
+	}
+
+	// The buffer can't be cleared selectively, and it must not
+	// bring the forgotten messages back into answers.
+	err = s.mem.Clear(pkgContext.CtxWithUserID(ctx, user))
+	if err != nil {
+		return n, fmt.Errorf("clear memory: %w", err)
+	}
+	return n, nil
+}
+
+func forgetFilter(user models.UserID, f Forget) filter {
+	match := func(key string, value any) filterEntry {
+		return filterEntry{Key: key, Match: &filterEntryMatch{Value: value}}
+	}
+
+	must := []filterEntry{match("user_id", user.String())}
+	if f.MessageID != 0 {
+		must = append(must, match("chat_id", f.ChatID), match("message_id", f.MessageID))
+	}
+	if f.Topic != "" {
+		must = append(must, match("topic", f.Topic))
+	}
+	if !f.Since.IsZero() || !f.Until.IsZero() {
+		r := &filterEntryRange{}
+		if !f.Since.IsZero() {
+			since := f.Since.Unix()
+			r.Gte = &since
+		}
+		if !f.Until.IsZero() {
+			until := f.Until.Unix()
+			r.Lt = &until
+		}
+		must = append(must, filterEntry{Key: "time_send", Range: r})
+	}
+	return filter{Must: must}
+}
@@ -0,0 +1,46 @@
+package chat
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"tgpt/internal/models"
+)
+
+func TestForgetFilter(t *testing.T) {
+	user := models.NewUserID(-100, 7)
+	for _, tc := range []struct {
+		name   string
+		forget Forget
+		want   string
+	}{
+		{"all", Forget{}, `{"must":[{"key":"user_id","match":{"value":"-100:7"}}]}`},
+		{"message", Forget{ChatID: -100, MessageID: 42}, `{"must":[
+			{"key":"user_id","match":{"value":"-100:7"}},
+			{"key":"chat_id","match":{"value":-100}},
+			{"key":"message_id","match":{"value":42}}
+		]}`},
+		{"topic and range", Forget{
+			Topic: "#travel",
+			Since: time.Unix(1714521600, 0),
+			Until: time.Unix(1717200000, 0),
+		}, `{"must":[
+			{"key":"user_id","match":{"value":"-100:7"}},
+			{"key":"topic","match":{"value":"#travel"}},
+			{"key":"time_send","range":{"gte":1714521600,"lt":1717200000}}
+		]}`},
+		{"since only", Forget{Since: time.Unix(1714521600, 0)}, `{"must":[
+			{"key":"user_id","match":{"value":"-100:7"}},
+			{"key":"time_send","range":{"gte":1714521600}}
+		]}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			raw, err := json.Marshal(forgetFilter(user, tc.forget))
+			require.NoError(t, err)
+			require.JSONEq(t, tc.want, string(raw))
+		})
+	}
+}
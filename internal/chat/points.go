@@ -0,0 +1,140 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/tmc/langchaingo/embeddings"
+	"github.com/tmc/langchaingo/schema"
+	"github.com/tmc/langchaingo/vectorstores/qdrant"
+)
+
+const (
+	collectionName = "chat"
+	// contentKey is where the langchaingo store keeps the page content.
+	contentKey = "content"
+)
+
+// pointNamespace makes point ids derived from Telegram ids distinct
+// from ids derived from anything else.
+var pointNamespace = uuid.MustParse("6f1c2b8e-4a53-4f0e-9a5d-2f8f3c7e1d90")
+
+// pointStore writes documents under ids chosen by the service, which the
+// langchaingo store doesn't allow, so they can be replaced later.
+// Its documents are read back through the langchaingo store.
+type pointStore struct {
+	url        url.URL
+	collection string
+	embedder   embeddings.Embedder
+}
+
+func newPointStore(u url.URL, embedder embeddings.Embedder) *pointStore {
+	return &pointStore{
+		url:        u,
+		collection: collectionName,
+		embedder:   embedder,
+	}
+}
+
+// pointID derives the id of a message document. Documents of the same
+// message get the same id, so saving a message again replaces it.
+func pointID(chatID, messageID int64, chunk int) string {
+	name := strconv.FormatInt(chatID, 10) + ":" + strconv.FormatInt(messageID, 10) + ":" + strconv.Itoa(chunk)
+	return uuid.NewSHA1(pointNamespace, []byte(name)).String()
+}
+
+type upsertPoint struct {
+	ID      string         `json:"id"`
+	Vector  []float32      `json:"vector"`
+	Payload map[string]any `json:"payload"`
+}
+
+// upsert embeds the documents and stores them under the ids,
+// replacing the documents already stored under them.
+func (p *pointStore) upsert(ctx context.Context, ids []string, docs []schema.Document) error {
+	texts := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		texts = append(texts, doc.PageContent)
+	}
+	vectors, err := p.embedder.EmbedDocuments(ctx, texts)
+	if err != nil {
+		return fmt.Errorf("embed documents: %w", err)
+	}
+	if len(vectors) != len(docs) {
+		return fmt.Errorf("got %d vectors for %d documents", len(vectors), len(docs))
+	}
+
+	points := make([]upsertPoint, 0, len(docs))
+	for i, doc := range docs {
+		payload := make(map[string]any, len(doc.Metadata)+1)
+		for k, v := range doc.Metadata {
+			payload[k] = v
+		}
+		payload[contentKey] = doc.PageContent
+		points = append(points, upsertPoint{ID: ids[i], Vector: vectors[i], Payload: payload})
+	}
+
+	return p.do(ctx, http.MethodPut, map[string]any{"points": points}, nil, "points")
+}
+
+// exists reports whether a point with the id is stored.
+func (p *pointStore) exists(ctx context.Context, id string) (bool, error) {
+	var found []struct {
+		ID string `json:"id"`
+	}
+	err := p.do(ctx, http.MethodPost, map[string]any{"ids": []string{id}}, &found, "points")
+	if err != nil {
+		return false, err
+	}
+	return len(found) > 0, nil
+}
+
+// count returns the number of points matching the filter.
+func (p *pointStore) count(ctx context.Context, f filter) (int, error) {
+	var result struct {
+		Count int `json:"count"`
+	}
+	err := p.do(ctx, http.MethodPost, map[string]any{"filter": f, "exact": true}, &result, "points", "count")
+	if err != nil {
+		return 0, err
+	}
+	return result.Count, nil
+}
+
+// delete removes the points matching the filter.
+func (p *pointStore) delete(ctx context.Context, f filter) error {
+	return p.do(ctx, http.MethodPost, map[string]any{"filter": f}, nil, "points", "delete")
+}
+
+// do calls the points API of the collection and decodes the result into result.
+func (p *pointStore) do(ctx context.Context, method string, payload, result any, path ...string) error {
+	u := p.url.JoinPath(append([]string{"collections", p.collection}, path...)...)
+	body, status, err := qdrant.DoRequest(ctx, *u, "", method, payload)
+	if err != nil {
+		return fmt.Errorf("qdrant request: %w", err)
+	}
+	defer body.Close()
+
+	if status != http.StatusOK {
+		msg, _ := io.ReadAll(body)
+		return fmt.Errorf("qdrant status %d: %s", status, msg)
+	}
+	if result == nil {
+		return nil
+	}
+
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+	}
+	err = json.NewDecoder(body).Decode(&resp)
+	if err != nil {
+		return fmt.Errorf("decode qdrant response: %w", err)
+	}
+	return json.Unmarshal(resp.Result, result)
+}
@@ -0,0 +1,88 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/schema"
+)
+
+type fakeEmbedder struct{}
+
+func (fakeEmbedder) EmbedDocuments(_ context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = []float32{float32(len(text))}
+	}
+	return vectors, nil
+}
+
+func (fakeEmbedder) EmbedQuery(_ context.Context, text string) ([]float32, error) {
+	return []float32{float32(len(text))}, nil
+}
+
+func TestPointID(t *testing.T) {
+	require.Equal(t, pointID(-100, 42, 0), pointID(-100, 42, 0))
+	require.NotEqual(t, pointID(-100, 42, 0), pointID(-100, 42, 1))
+	require.NotEqual(t, pointID(-100, 42, 0), pointID(-100, 43, 0))
+	require.NotEqual(t, pointID(-100, 42, 0), pointID(100, 42, 0))
+}
+
+func TestPointStore(t *testing.T) {
+	stored := map[string]map[string]any{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/collections/chat/points", r.URL.Path)
+		switch r.Method {
+		case http.MethodPut:
+			var body struct {
+				Points []upsertPoint `json:"points"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			for _, p := range body.Points {
+				stored[p.ID] = p.Payload
+			}
+			_, _ = w.Write([]byte(`{"result":{"status":"completed"}}`))
+		case http.MethodPost:
+			var body struct {
+				IDs []string `json:"ids"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			var found []map[string]any
+			for _, id := range body.IDs {
+				if _, ok := stored[id]; ok {
+					found = append(found, map[string]any{"id": id})
+				}
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"result": found})
+		}
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	p := newPointStore(*u, fakeEmbedder{})
+	ctx := context.Background()
+	id := pointID(1, 2, 0)
+
+	ok, err := p.exists(ctx, id)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	for _, text := range []string{"first", "edited"} {
+		err = p.upsert(ctx, []string{id}, []schema.Document{
+			{PageContent: text, Metadata: map[string]any{"topic": "#default"}},
+		})
+		require.NoError(t, err)
+	}
+
+	ok, err = p.exists(ctx, id)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Len(t, stored, 1)
+	require.Equal(t, map[string]any{"topic": "#default", "content": "edited"}, stored[id])
+}
@@ -0,0 +1,58 @@
+package chat
+
+import (
+	"context"
+
+	"github.com/tmc/langchaingo/schema"
+)
+
+type Stage string
+
+const (
+	StageSaving     Stage = "saving"
+	StageRetrieving Stage = "retrieving"
+	StageGenerating Stage = "generating"
+)
+
+// ProgressFunc is notified when query processing enters a new stage.
+type ProgressFunc func(ctx context.Context, stage Stage)
+
+type ctxKeyProgress struct{}
+
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, ctxKeyProgress{}, fn)
+}
+
+func reportProgress(ctx context.Context, stage Stage) {
+	fn, ok := ctx.Value(ctxKeyProgress{}).(ProgressFunc)
+	if !ok {
+		return
+	}
+	fn(ctx, stage)
+}
+
+// recallRetriever reports retrieval and the generation that follows it
+// and keeps the retrieved documents as sources of the answer.
+type recallRetriever struct {
+	schema.Retriever
+	// extraQuery is appended to every query.
+	extraQuery string
+	docs       []schema.Document
+}
+
+func (r *recallRetriever) GetRelevantDocuments(
+	ctx context.Context,
+	query string,
+) ([]schema.Document, error) {
+	reportProgress(ctx, StageRetrieving)
+	if r.extraQuery != "" {
+		query += "\n" + r.extraQuery
+	}
+	docs, err := r.Retriever.GetRelevantDocuments(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	r.docs = docs
+	reportProgress(ctx, StageGenerating)
+	return docs, nil
+}
@@ -0,0 +1,79 @@
+package chat
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/memory"
+	"github.com/tmc/langchaingo/schema"
+	"github.com/tmc/langchaingo/vectorstores"
+
+	tgptmemory "tgpt/internal/memory"
+	"tgpt/internal/models"
+	pkgContext "tgpt/pkg/context"
+)
+
+type fakeLLM struct{ answer string }
+
+func (l fakeLLM) GenerateContent(context.Context, []llms.MessageContent, ...llms.CallOption) (*llms.ContentResponse, error) {
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: l.answer}}}, nil
+}
+
+func (l fakeLLM) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, l, prompt, options...)
+}
+
+type fakeStore struct{ docs []schema.Document }
+
+func (s fakeStore) AddDocuments(context.Context, []schema.Document, ...vectorstores.Option) ([]string, error) {
+	return nil, nil
+}
+
+func (s fakeStore) SimilaritySearch(context.Context, string, int, ...vectorstores.Option) ([]schema.Document, error) {
+	return s.docs, nil
+}
+
+func TestRecallWithoutConversation(t *testing.T) {
+	s := &Service{
+		store: fakeStore{docs: []schema.Document{{PageContent: "'s1kai': dubai was amazing"}}},
+		llm:   fakeLLM{answer: "You were in Dubai."},
+		mem: tgptmemory.NewPersonalized(func() schema.Memory {
+			return memory.NewConversationBuffer()
+		}),
+	}
+	user := models.NewUserID(1, 1)
+	question := models.Message{UserName: user, Topic: "#travel", Text: "where was I?", Command: models.CommandRecall}
+
+	history := func() string {
+		vars, err := s.mem.LoadMemoryVariables(pkgContext.CtxWithUserID(context.Background(), user), nil)
+		require.NoError(t, err)
+		h, _ := vars["history"].(string)
+		return h
+	}
+
+	// Inline recalls run next to the chat of the same user.
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			answer, err := s.Recall(context.Background(), question)
+			require.NoError(t, err)
+			require.Equal(t, "You were in Dubai.", answer.Text)
+			require.Len(t, answer.Sources, 1)
+		}()
+		go func() {
+			defer wg.Done()
+			_, _ = s.mem.LoadMemoryVariables(pkgContext.CtxWithUserID(context.Background(), user), nil)
+		}()
+	}
+	wg.Wait()
+	require.Empty(t, history(), "recall outside of the conversation is not remembered")
+
+	_, err := s.HandleQuery(context.Background(), question, emptyHandler)
+	require.NoError(t, err)
+	require.Contains(t, history(), "where was I?")
+}
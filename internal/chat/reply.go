@@ -0,0 +1,53 @@
+package chat
+
+import (
+	"github.com/tmc/langchaingo/chains"
+	"github.com/tmc/langchaingo/prompts"
+	"github.com/tmc/langchaingo/schema"
+
+	"tgpt/internal/models"
+)
+
+// recallTemplate is the default langchaingo stuff QA prompt
+// with the message the question replies to.
+const recallTemplate = `Use the following pieces of context to answer the question at the end. If you don't know the answer, just say that you don't know, don't try to make up an answer.
+
+{{.context}}
+{{if .quote}}
+The question is a reply to this message from '{{.quote_from}}', words like "this" and "it" in the question refer to it:
+{{.quote}}
+{{end}}
+Question: {{.question}}
+Helpful Answer:`
+
+// recallPrompt renders the recall prompt of the message. The quote is
+// passed as a partial variable, so its text is never parsed as a template.
+func recallPrompt(message models.Message) prompts.PromptTemplate {
+	prompt := prompts.NewPromptTemplate(recallTemplate, []string{"context", "question"})
+	prompt.PartialVariables = map[string]any{"quote": "", "quote_from": ""}
+	if q := message.ReplyTo; q != nil {
+		prompt.PartialVariables["quote"] = q.Text
+		prompt.PartialVariables["quote_from"] = q.From
+	}
+	return prompt
+}
+
+// recallChain answers the question from the retrieved documents,
+// taking into account the message the question replies to.
+func (s *Service) recallChain(
+	message models.Message,
+	retriever *recallRetriever,
+	mem schema.Memory,
+) chains.ConversationalRetrievalQA {
+	if message.ReplyTo != nil {
+		// Look for what the quoted message is about too,
+		// the question alone may not say it.
+		retriever.extraQuery = message.ReplyTo.Text
+	}
+	return chains.NewConversationalRetrievalQA(
+		chains.NewStuffDocuments(chains.NewLLMChain(s.llm, recallPrompt(message))),
+		chains.LoadCondenseQuestionGenerator(s.llm),
+		retriever,
+		mem,
+	)
+}
@@ -1,9 +1,13 @@
 package chat
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/tmc/langchaingo/chains"
 	"github.com/tmc/langchaingo/embeddings"
@@ -20,11 +24,6 @@ import (
 	pkgContext "tgpt/pkg/context"
 )
 
-const (
-	commandPrefixEn_US = "!bro "
-	commandPrefixRu_RU = "!бро "
-)
-
 const (
 	ModelLlama2uncensored = "llama2-uncensored"
 	ModelLlama3           = "llama3.1"
@@ -35,20 +34,49 @@ const (
 	modelTypeOpenAI = "openai"
 )
 
+// ErrNotStored is returned for messages that have no stored document.
+var ErrNotStored = errors.New("message is not stored")
+
 type Handler func(ctx context.Context, chunk []byte) error
 
+// Answer is the result of a recall.
+type Answer struct {
+	Text    string
+	Sources []Source
+}
+
+// Source is a stored document an answer is based on.
+type Source struct {
+	Content  string
+	Metadata map[string]any
+	Score    float32
+}
+
 type Config struct {
-	ModelType  string
-	ModelName  string
-	OllamaAddr string
-	KeepAlive  string
-	QdrantAddr string
-	ChatGPTKey string
+	ModelType string
+	ModelName string
+	// VisionModelName is the model photos are described with.
+	// Photos are stored with their caption only if it is empty.
+	VisionModelName string
+	OllamaAddr      string
+	KeepAlive       string
+	QdrantAddr      string
+	ChatGPTKey      string
+	// ChunkSize and ChunkOverlap configure how uploaded files
+	// are split, zero means the default.
+	ChunkSize    int
+	ChunkOverlap int
 }
 
 type Service struct {
-	store vectorstores.VectorStore
-	llm   llms.Model
+	store     vectorstores.VectorStore
+	points    *pointStore
+	llm       llms.Model
+	vision    llms.Model
+	modelType string
+
+	chunkSize    int
+	chunkOverlap int
 
 	mem schema.Memory
 }
@@ -72,7 +100,11 @@ func newModel(cfg Config) (model, error) {
 			ollama.WithServerURL(cfg.OllamaAddr),
 		)
 	case modelTypeOpenAI:
-		m, err = openai.New(openai.WithToken(cfg.ChatGPTKey))
+		opts := []openai.Option{openai.WithToken(cfg.ChatGPTKey)}
+		if cfg.ModelName != "" {
+			opts = append(opts, openai.WithModel(cfg.ModelName))
+		}
+		m, err = openai.New(opts...)
 	default:
 		return nil, fmt.Errorf("unknown model type: %s", cfg.ModelType)
 	}
@@ -89,6 +121,16 @@ func NewService(cfg Config) (*Service, error) {
 		return nil, fmt.Errorf("model: %w", err)
 	}
 
+	var vision llms.Model
+	if cfg.VisionModelName != "" {
+		visionCfg := cfg
+		visionCfg.ModelName = cfg.VisionModelName
+		vision, err = newModel(visionCfg)
+		if err != nil {
+			return nil, fmt.Errorf("vision model: %w", err)
+		}
+	}
+
 	e, err := embeddings.NewEmbedder(mod)
 	if err != nil {
 		return nil, fmt.Errorf("can't build embeder: %w", err)
@@ -102,7 +144,7 @@ func NewService(cfg Config) (*Service, error) {
 	q, err := qdrant.New(
 		qdrant.WithURL(*qdrantUrl),
 		qdrant.WithEmbedder(e),
-		qdrant.WithCollectionName("chat"),
+		qdrant.WithCollectionName(collectionName),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("can't connect to qdrant: %w", err)
@@ -112,9 +154,15 @@ func NewService(cfg Config) (*Service, error) {
 	})
 
 	return &Service{
-		store: q,
-		llm:   mod,
-		mem:   mem,
+		store:     q,
+		points:    newPointStore(*qdrantUrl, e),
+		llm:       mod,
+		vision:    vision,
+		modelType: cfg.ModelType,
+		mem:       mem,
+
+		chunkSize:    cmp.Or(cfg.ChunkSize, defaultChunkSize),
+		chunkOverlap: cmp.Or(cfg.ChunkOverlap, defaultChunkOverlap),
 	}, nil
 }
 
@@ -122,20 +170,32 @@ func (s *Service) HandleQuery(
 	ctx context.Context,
 	message models.Message,
 	handler Handler,
-) error {
+) (Answer, error) {
 	ctx = pkgContext.CtxWithUserID(ctx, message.UserName)
 	switch message.Command {
-	case commandPrefixEn_US, commandPrefixRu_RU:
-		return s.recall(ctx, message, handler)
+	case models.CommandRecall:
+		return s.recall(ctx, message, handler, s.mem)
 	default:
-		return s.handleMessage(ctx, message)
+		return Answer{}, s.handleMessage(ctx, message)
 	}
 }
 
+// Recall answers the message without streaming the answer. It runs
+// outside of the user's conversation, so it neither reads nor writes
+// the conversation memory and can run concurrently with it.
+func (s *Service) Recall(
+	ctx context.Context,
+	message models.Message,
+) (Answer, error) {
+	ctx = pkgContext.CtxWithUserID(ctx, message.UserName)
+	return s.recall(ctx, message, nil, memory.NewConversationBuffer())
+}
+
 func (s *Service) handleMessage(
 	ctx context.Context,
 	message models.Message,
 ) error {
+	reportProgress(ctx, StageSaving)
 	err := s.saveDocument(ctx, message)
 	if err != nil {
 		return fmt.Errorf("save document: %w", err)
@@ -152,71 +212,178 @@ func (s *Service) saveDocument(
 	ctx context.Context,
 	message models.Message,
 ) error {
+	return s.addDocuments(ctx, message, []schema.Document{
+		{
+			PageContent: author(message) + ": " + message.Text,
+			Metadata:    documentMetadata(message),
+		},
+	})
+}
+
+// EditMessage replaces the stored document of an edited message.
+// It returns ErrNotStored if the message was never saved.
+func (s *Service) EditMessage(ctx context.Context, message models.Message) error {
+	if message.MessageID == 0 {
+		return ErrNotStored
+	}
+	ok, err := s.points.exists(ctx, pointID(message.ChatID, message.MessageID, 0))
+	if err != nil {
+		return fmt.Errorf("find document: %w", err)
+	}
+	if !ok {
+		return ErrNotStored
+	}
+
+	err = s.saveDocument(ctx, message)
+	if err != nil {
+		return fmt.Errorf("save document: %w", err)
+	}
+	return nil
+}
+
+// addDocuments stores the documents of the message. Documents of Telegram
+// messages get ids derived from the message, one per chunk, so they
+// can be found and replaced later.
+func (s *Service) addDocuments(ctx context.Context, message models.Message, docs []schema.Document) error {
+	if message.MessageID == 0 {
+		_, err := s.store.AddDocuments(ctx, docs)
+		if err != nil {
+			return fmt.Errorf("add documents: %w", err)
+		}
+		return nil
+	}
+
+	ids := make([]string, 0, len(docs))
+	for i := range docs {
+		ids = append(ids, pointID(message.ChatID, message.MessageID, i))
+	}
+	err := s.points.upsert(ctx, ids, docs)
+	if err != nil {
+		return fmt.Errorf("upsert documents: %w", err)
+	}
+	return nil
+}
+
+// documentMetadata is the payload stored with every document of the message,
+// the recall filters are built on it.
+func documentMetadata(message models.Message) map[string]any {
 	metaData := map[string]any{
 		"user_id":      message.UserName.String(),
 		"from_user_id": message.FromUserName.String(),
 		"topic":        message.Topic,
+		"source":       message.Source,
+	}
+	if message.MessageID != 0 {
+		metaData["chat_id"] = message.ChatID
+		metaData["message_id"] = message.MessageID
+	}
+	if message.TopicName != "" {
+		metaData["topic_name"] = message.TopicName
+	}
+	if !message.TimeSend.IsZero() {
+		metaData["time_send"] = message.TimeSend.Unix()
+	}
+	if message.FileID != "" {
+		metaData["file_id"] = message.FileID
 	}
+	if message.DocumentID != "" {
+		metaData["document_id"] = message.DocumentID
+	}
+	if o := message.Origin; o != nil {
+		metaData["forward_type"] = o.Type
+		metaData["forward_from"] = o.Name
+		metaData["forward_date"] = o.Date.UTC().Format(time.RFC3339)
+	}
+	return metaData
+}
 
-	_, err := s.store.AddDocuments(
-		ctx,
-		[]schema.Document{
-			{
-				PageContent: "'" + message.FromUserName.String() + "': " + message.Text,
-				Metadata:    metaData,
-			},
-		},
-	)
-	if err != nil {
-		return fmt.Errorf("add documents: %w", err)
+// author introduces the message in the document content, so the
+// original author of a forwarded message can be searched for.
+func author(message models.Message) string {
+	s := "'" + message.FromUserName.String() + "'"
+	if o := message.Origin; o != nil {
+		from := "a hidden user"
+		if o.Name != "" {
+			from = strings.ReplaceAll(o.Type, "_", " ") + " '" + o.Name + "'"
+		}
+		s += " (forwarded from " + from + ", originally sent " + o.Date.UTC().Format(time.DateOnly) + ")"
 	}
-	return nil
+	return s
 }
 
+// recall answers the message from the stored documents with mem as the
+// conversation. The answer is streamed into handler unless it is nil.
 func (s *Service) recall(
 	ctx context.Context,
 	message models.Message,
 	handler Handler,
-) error {
-	filters := filter{
-		Must: []filterEntry{
-			{
-				Key: "topic",
-				Match: filterEntryMatch{
-					Value: message.Topic,
-				},
-			},
-			{
-				Key: "user_id",
-				Match: filterEntryMatch{
-					Value: message.UserName.String(),
-				},
-			},
-		},
+	mem schema.Memory,
+) (Answer, error) {
+	retriever := &recallRetriever{Retriever: vectorstores.ToRetriever(
+		s.store,
+		10,
+		vectorstores.WithFilters(recallFilter(message)),
+	)}
+	conv := s.recallChain(message, retriever, mem)
+
+	var opts []chains.ChainCallOption
+	if handler != nil {
+		opts = append(opts, chains.WithStreamingFunc(handler))
 	}
 
-	conv := chains.NewConversationalRetrievalQAFromLLM(
-		s.llm,
-		vectorstores.ToRetriever(
-			s.store,
-			10,
-			vectorstores.WithFilters(filters),
-		),
-		s.mem,
-	)
-
-	_, err := chains.Call(
+	out, err := chains.Call(
 		ctx,
 		conv,
 		map[string]any{
 			"question": message.Text,
 		},
-		chains.WithStreamingFunc(handler),
+		opts...,
 	)
 	if err != nil {
-		return fmt.Errorf("call: %w", err)
+		return Answer{}, fmt.Errorf("call: %w", err)
+	}
+
+	answer := Answer{}
+	answer.Text, _ = out[conv.OutputKey].(string)
+	for _, doc := range retriever.docs {
+		answer.Sources = append(answer.Sources, Source{
+			Content:  doc.PageContent,
+			Metadata: doc.Metadata,
+			Score:    doc.Score,
+		})
+	}
+	return answer, nil
+}
+
+// recallFilter limits the recall to the user's documents of the message
+// topic, or to the chunks of a single document if the message is about one.
+func recallFilter(message models.Message) filter {
+	scope := filterEntry{
+		Key: "topic",
+		Match: &filterEntryMatch{
+			Value: message.Topic,
+		},
+	}
+	if message.DocumentID != "" {
+		scope = filterEntry{
+			Key: "document_id",
+			Match: &filterEntryMatch{
+				Value: message.DocumentID,
+			},
+		}
+	}
+
+	return filter{
+		Must: []filterEntry{
+			scope,
+			{
+				Key: "user_id",
+				Match: &filterEntryMatch{
+					Value: message.UserName.String(),
+				},
+			},
+		},
 	}
-	return nil
 }
 
 func (s *Service) remember(
@@ -231,12 +398,18 @@ type filter struct {
 }
 
 type filterEntry struct {
-	Key   string           `json:"key"`
-	Match filterEntryMatch `json:"match"`
+	Key   string            `json:"key"`
+	Match *filterEntryMatch `json:"match,omitempty"`
+	Range *filterEntryRange `json:"range,omitempty"`
 }
 
 type filterEntryMatch struct {
-	Value string `json:"value"`
+	Value any `json:"value"`
+}
+
+type filterEntryRange struct {
+	Gte *int64 `json:"gte,omitempty"`
+	Lt  *int64 `json:"lt,omitempty"`
 }
 
 func emptyHandler(_ context.Context, _ []byte) error {
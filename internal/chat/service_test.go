@@ -22,7 +22,7 @@ func TestChat(t *testing.T) {
 			QdrantAddr: "http://localhost:6333",
 		})
 		require.NoError(t, err)
-		err = s.HandleQuery(
+		_, err = s.HandleQuery(
 			ctx,
 			models.Message{
 				Text:     "this autumn i've been in dubai and it was amazing",
@@ -34,7 +34,7 @@ func TestChat(t *testing.T) {
 		)
 		require.NoError(t, err)
 
-		err = s.HandleQuery(
+		_, err = s.HandleQuery(
 			ctx,
 			models.Message{
 				Text:     "two days ago i back from vladiostok and that trip was horrible",
@@ -45,13 +45,13 @@ func TestChat(t *testing.T) {
 			emptyHandler,
 		)
 		require.NoError(t, err)
-		err = s.HandleQuery(ctx, models.Message{
+		_, err = s.HandleQuery(ctx, models.Message{
 			TimeSend:     time.Now(),
 			UserName:     userID,
 			FromUserName: userID,
 			Text:         "summurize all my travels this year",
 			Topic:        "travel",
-			Command:      commandPrefixRu_RU,
+			Command:      models.CommandRecall,
 		}, func(ctx context.Context, chunk []byte) error {
 			t.Log(string(chunk))
 			return nil
@@ -71,7 +71,7 @@ func TestChat(t *testing.T) {
 			QdrantAddr: "http://localhost:6333",
 		})
 		require.NoError(t, err)
-		err = s.HandleQuery(
+		_, err = s.HandleQuery(
 			ctx,
 			models.Message{
 				Text:     "this autumn i've been in dubai and it was amazing",
@@ -83,7 +83,7 @@ func TestChat(t *testing.T) {
 		)
 		require.NoError(t, err)
 
-		err = s.HandleQuery(
+		_, err = s.HandleQuery(
 			ctx,
 			models.Message{
 				Text:     "two days ago i back from vladiostok and that trip was horrible",
@@ -95,13 +95,13 @@ func TestChat(t *testing.T) {
 		)
 		require.NoError(t, err)
 
-		err = s.HandleQuery(ctx, models.Message{
+		_, err = s.HandleQuery(ctx, models.Message{
 			TimeSend:     time.Now(),
 			UserName:     userID,
 			FromUserName: userID,
 			Text:         "summurize all my travels this year",
 			Topic:        "travel",
-			Command:      commandPrefixRu_RU,
+			Command:      models.CommandRecall,
 		}, func(ctx context.Context, chunk []byte) error {
 			t.Log(string(chunk))
 			return nil
@@ -110,3 +110,40 @@ func TestChat(t *testing.T) {
 		require.NoError(t, err)
 	})
 }
+
+func TestAuthor(t *testing.T) {
+	from := models.UserID{ID: "s1kai"}
+	require.Equal(t, "'s1kai'", author(models.Message{FromUserName: from}))
+
+	sent := time.Date(2024, time.September, 29, 11, 33, 20, 0, time.UTC)
+	require.Equal(t,
+		"'s1kai' (forwarded from channel 'Go Weekly', originally sent 2024-09-29)",
+		author(models.Message{
+			FromUserName: from,
+			Origin:       &models.Origin{Type: "channel", Name: "Go Weekly", Date: sent},
+		}),
+	)
+	require.Equal(t,
+		"'s1kai' (forwarded from a hidden user, originally sent 2024-09-29)",
+		author(models.Message{
+			FromUserName: from,
+			Origin:       &models.Origin{Type: "hidden_user", Date: sent},
+		}),
+	)
+}
+
+func TestRecallPrompt(t *testing.T) {
+	values := map[string]any{"context": "'s1kai': dubai was amazing", "question": "when was it?"}
+
+	text, err := recallPrompt(models.Message{}).Format(values)
+	require.NoError(t, err)
+	require.NotContains(t, text, "reply")
+
+	text, err = recallPrompt(models.Message{
+		ReplyTo: &models.Quote{From: "tgpt_bot", Text: "You were in {{.context}} Dubai."},
+	}).Format(values)
+	require.NoError(t, err)
+	require.Contains(t, text, "reply to this message from 'tgpt_bot'")
+	require.Contains(t, text, "You were in {{.context}} Dubai.")
+	require.Contains(t, text, "Question: when was it?")
+}
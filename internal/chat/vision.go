@@ -0,0 +1,46 @@
+package chat
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+const describeImagePrompt = "Describe this image in detail so it can be found later by a text search. " +
+	"Transcribe any visible text, like notes on a whiteboard, word for word."
+
+// ErrVisionDisabled is returned by DescribeImage when no vision model is configured.
+var ErrVisionDisabled = errors.New("vision model is not configured")
+
+// DescribeImage returns a text description of the image made by the vision model.
+func (s *Service) DescribeImage(ctx context.Context, mimeType string, image []byte) (string, error) {
+	if s.vision == nil {
+		return "", ErrVisionDisabled
+	}
+
+	var part llms.ContentPart
+	switch s.modelType {
+	case modelTypeOpenAI:
+		// The OpenAI client only passes images by URL.
+		part = llms.ImageURLPart("data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(image))
+	default:
+		part = llms.BinaryPart(mimeType, image)
+	}
+
+	resp, err := s.vision.GenerateContent(ctx, []llms.MessageContent{
+		{
+			Role:  llms.ChatMessageTypeHuman,
+			Parts: []llms.ContentPart{llms.TextPart(describeImagePrompt), part},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("generate content: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return "", errors.New("empty vision model response")
+	}
+	return resp.Choices[0].Content, nil
+}
@@ -8,4 +8,6 @@ func (t Command) String() string {
 
 const (
 	CommandSummarize = Command("summarize")
+	// CommandRecall asks a question about the stored messages.
+	CommandRecall = Command("recall")
 )
@@ -1,5 +1,9 @@
 package models
 
+import (
+	"strconv"
+)
+
 type ID string
 
 func (t ID) String() string {
@@ -7,3 +11,9 @@ func (t ID) String() string {
 }
 
 type UserID struct{ ID }
+
+// NewUserID identifies a member of a chat. Memories are keyed
+// by it, so members of a group do not share them.
+func NewUserID(chatID, userID int64) UserID {
+	return UserID{ID: ID(strconv.FormatInt(chatID, 10) + ":" + strconv.FormatInt(userID, 10))}
+}
@@ -4,11 +4,52 @@ import (
 	"time"
 )
 
+const (
+	SourceText     = "text"
+	SourceVoice    = "voice"
+	SourcePhoto    = "photo"
+	SourceDocument = "document"
+)
+
 type Message struct {
+	// ChatID and MessageID identify the Telegram message,
+	// they are zero for messages made up by the service.
+	ChatID    int64
+	MessageID int64
+	// ThreadID is the forum topic the message was sent to.
+	ThreadID     int64
 	TimeSend     time.Time
 	UserName     UserID
 	FromUserName UserID
 	Text         string
 	Topic        string
-	Command      string
+	// TopicName is the name of the forum topic the message was sent to.
+	// It is only shown to users, Topic identifies the topic.
+	TopicName string
+	Command   Command
+	// Source tells what kind of message the text comes from.
+	Source string
+	// FileID is the Telegram file the message was made from, if any.
+	FileID string
+	// DocumentID identifies an uploaded document. It is set on the chunks
+	// of the document and on questions scoped to that document.
+	DocumentID string
+	// Origin is set for forwarded messages.
+	Origin *Origin
+	// ReplyTo is the message this one replies to.
+	ReplyTo *Quote
+}
+
+// Quote is a message another message replies to.
+type Quote struct {
+	From string
+	Text string
+}
+
+// Origin is where a forwarded message was originally sent.
+type Origin struct {
+	// Type is user, hidden_user, chat or channel.
+	Type string
+	Name string
+	Date time.Time
 }
@@ -0,0 +1,93 @@
+package stt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// Transcriber converts speech to text.
+type Transcriber interface {
+	Transcribe(ctx context.Context, fileName string, audio io.Reader) (string, error)
+}
+
+// OpenAI transcribes through an OpenAI compatible /audio/transcriptions
+// endpoint, like the OpenAI API itself or a local whisper server.
+type OpenAI struct {
+	client  *http.Client
+	baseURL string
+	apiKey  string
+	model   string
+}
+
+var _ Transcriber = (*OpenAI)(nil)
+
+// NewOpenAI creates a transcriber. baseURL is the API root,
+// e.g. https://api.openai.com/v1, apiKey may be empty.
+func NewOpenAI(cli *http.Client, baseURL, apiKey, model string) *OpenAI {
+	return &OpenAI{
+		client:  cli,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		apiKey:  apiKey,
+		model:   model,
+	}
+}
+
+type transcriptionResponse struct {
+	Text string `json:"text"`
+}
+
+func (o *OpenAI) Transcribe(ctx context.Context, fileName string, audio io.Reader) (string, error) {
+	r, w := io.Pipe()
+	m := multipart.NewWriter(w)
+
+	go func() {
+		defer w.Close()
+		defer m.Close()
+
+		if err := m.WriteField("model", o.model); err != nil {
+			w.CloseWithError(err)
+			return
+		}
+		part, err := m.CreateFormFile("file", fileName)
+		if err != nil {
+			w.CloseWithError(err)
+			return
+		}
+		if _, err = io.Copy(part, audio); err != nil {
+			w.CloseWithError(err)
+			return
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+"/audio/transcriptions", r)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", m.FormDataContentType())
+	if o.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("transcription failed: %s: %s", resp.Status, body)
+	}
+
+	var tr transcriptionResponse
+	err = json.NewDecoder(resp.Body).Decode(&tr)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+	return strings.TrimSpace(tr.Text), nil
+}
@@ -0,0 +1,48 @@
+package stt
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAITranscribe(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/audio/transcriptions", r.URL.Path)
+		require.Equal(t, "Bearer KEY", r.Header.Get("Authorization"))
+		require.Equal(t, "whisper-1", r.FormValue("model"))
+
+		f, h, err := r.FormFile("file")
+		require.NoError(t, err)
+		defer f.Close()
+		require.Equal(t, "voice.ogg", h.Filename)
+		data, err := io.ReadAll(f)
+		require.NoError(t, err)
+		require.Equal(t, "audio", string(data))
+
+		_, _ = w.Write([]byte(`{"text":" where was I in May? \n"}`))
+	}))
+	defer srv.Close()
+
+	o := NewOpenAI(srv.Client(), srv.URL+"/v1/", "KEY", "whisper-1")
+	text, err := o.Transcribe(context.Background(), "voice.ogg", strings.NewReader("audio"))
+	require.NoError(t, err)
+	require.Equal(t, "where was I in May?", text)
+}
+
+func TestOpenAITranscribeError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Empty(t, r.Header.Get("Authorization"))
+		http.Error(w, "unsupported format", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	o := NewOpenAI(srv.Client(), srv.URL, "", "whisper-1")
+	_, err := o.Transcribe(context.Background(), "voice.ogg", strings.NewReader("audio"))
+	require.ErrorContains(t, err, "400 Bad Request: unsupported format")
+}
@@ -0,0 +1,281 @@
+package telegram
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"tgpt/internal/chat"
+	"tgpt/internal/models"
+)
+
+// Buttons of an answer outlive it for this long.
+const answerTTL = 24 * time.Hour
+
+const sourcePreviewLength = 300
+
+const (
+	actionRegenerate = "regen"
+	actionStop       = "stop"
+	actionShorter    = "short"
+	actionLonger     = "long"
+	actionSources    = "src"
+)
+
+const (
+	textExpired    = "This answer is too old, ask again."
+	textStopping   = "Stopping..."
+	textNoSources  = "No sources."
+	textNotAllowed = "Not allowed."
+
+	instructionShorter = "Give a shorter answer."
+	instructionLonger  = "Give a longer, more detailed answer."
+)
+
+// answer is what buttons of an answer act on. Callback data
+// is limited to 64 bytes, so it only carries the answer id.
+type answer struct {
+	chatID   int64
+	question models.Message
+	// gen is the generation of the answer, stopping it
+	// must not stop answers generated later.
+	gen     *generation
+	sources []chat.Source
+	expires time.Time
+}
+
+type answerMessage struct {
+	chatID    int64
+	messageID int64
+}
+
+type answerRegistry struct {
+	mu      sync.Mutex
+	answers map[string]*answer
+	// byMessage finds answers by the messages they are written to.
+	byMessage map[answerMessage]string
+}
+
+func newAnswerRegistry() *answerRegistry {
+	return &answerRegistry{answers: map[string]*answer{}, byMessage: map[answerMessage]string{}}
+}
+
+func (r *answerRegistry) add(a *answer) string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	id := hex.EncodeToString(b)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for k, v := range r.answers {
+		if now.After(v.expires) {
+			delete(r.answers, k)
+		}
+	}
+	for k, id := range r.byMessage {
+		if _, ok := r.answers[id]; !ok {
+			delete(r.byMessage, k)
+		}
+	}
+	a.expires = now.Add(answerTTL)
+	r.answers[id] = a
+	return id
+}
+
+func (r *answerRegistry) get(id string) (*answer, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, ok := r.answers[id]
+	return a, ok
+}
+
+// finish stores the sources of a completed answer.
+func (r *answerRegistry) finish(id string, sources []chat.Source) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if a, ok := r.answers[id]; ok {
+		a.sources = sources
+	}
+}
+
+// bind records the messages the answer is written to.
+func (r *answerRegistry) bind(id string, chatID int64, messageIDs []int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.answers[id]; !ok {
+		return
+	}
+	for _, messageID := range messageIDs {
+		r.byMessage[answerMessage{chatID, messageID}] = id
+	}
+}
+
+// byMessageID returns the answer written to the message.
+func (r *answerRegistry) byMessageID(chatID, messageID int64) (*answer, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, ok := r.answers[r.byMessage[answerMessage{chatID, messageID}]]
+	return a, ok
+}
+
+func (r *answerRegistry) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.answers, id)
+}
+
+// followUp turns a reply to an answer into a question about the same
+// topic or document as the answered question. It reports false if the
+// message does not reply to an answer.
+func (h *Handler) followUp(message *Message, businessMessage *models.Message) bool {
+	r := message.repliedMessage()
+	if r == nil {
+		return false
+	}
+	a, ok := h.answers.byMessageID(r.Chat.ID, r.MessageID)
+	if !ok {
+		return false
+	}
+	businessMessage.Command = models.CommandRecall
+	if _, hashtag := cutHashtag(message.Text); hashtag == "" {
+		businessMessage.Topic = a.question.Topic
+	}
+	businessMessage.DocumentID = a.question.DocumentID
+	return true
+}
+
+func callbackData(action, id string) string {
+	return action + ":" + id
+}
+
+func parseCallbackData(data string) (action, id string) {
+	action, id, _ = strings.Cut(data, ":")
+	return action, id
+}
+
+func stopKeyboard(id string) *InlineKeyboardMarkup {
+	return &InlineKeyboardMarkup{InlineKeyboard: [][]InlineKeyboardButton{{
+		{Text: "⏹ Stop", CallbackData: callbackData(actionStop, id)},
+	}}}
+}
+
+func answerKeyboard(id string, withSources bool) *InlineKeyboardMarkup {
+	rows := [][]InlineKeyboardButton{{
+		{Text: "🔄 Regenerate", CallbackData: callbackData(actionRegenerate, id)},
+		{Text: "➖ Shorter", CallbackData: callbackData(actionShorter, id)},
+		{Text: "➕ Longer", CallbackData: callbackData(actionLonger, id)},
+	}}
+	if withSources {
+		rows = append(rows, []InlineKeyboardButton{
+			{Text: "📚 Show sources", CallbackData: callbackData(actionSources, id)},
+		})
+	}
+	return &InlineKeyboardMarkup{InlineKeyboard: rows}
+}
+
+// isStop reports whether the update asks to stop a generation.
+// Such updates must not wait behind the generation in the queue.
+func (u Update) isStop() bool {
+	switch {
+	case u.Message != nil:
+		return isStopCommand(u.Message.Text)
+	case u.CallbackQuery != nil:
+		action, _ := parseCallbackData(u.CallbackQuery.Data)
+		return action == actionStop
+	default:
+		return false
+	}
+}
+
+func (h *Handler) handleCallbackQuery(ctx context.Context, query *CallbackQuery) error {
+	if query.Message == nil {
+		return h.ackCallback(ctx, query, textExpired)
+	}
+	allowed := h.isAllowed(&Message{Chat: query.Message.Chat, From: &query.From})
+	if !allowed {
+		return h.ackCallback(ctx, query, textNotAllowed)
+	}
+
+	action, id := parseCallbackData(query.Data)
+	if action == actionForgetAll || action == actionForgetCancel {
+		return h.handleForgetCallback(ctx, query, action, id)
+	}
+
+	a, ok := h.answers.get(id)
+	if !ok {
+		return h.ackCallback(ctx, query, textExpired)
+	}
+	// The buttons act on the memories of the user who asked,
+	// so nobody else can press them.
+	if models.NewUserID(query.Message.Chat.ID, query.From.ID) != a.question.UserName {
+		return h.ackCallback(ctx, query, textNotAllowed)
+	}
+
+	switch action {
+	case actionStop:
+		text := textStopping
+		if !h.generations.stopGeneration(a.chatID, a.gen) {
+			text = ""
+		}
+		return h.ackCallback(ctx, query, text)
+	case actionSources:
+		err := h.ackCallback(ctx, query, "")
+		if err != nil {
+			return err
+		}
+		return h.sendSources(ctx, a, query.Message.MessageID)
+	case actionRegenerate, actionShorter, actionLonger:
+		err := h.ackCallback(ctx, query, "")
+		if err != nil {
+			return err
+		}
+		question := a.question
+		switch action {
+		case actionShorter:
+			question.Text += "\n\n" + instructionShorter
+		case actionLonger:
+			question.Text += "\n\n" + instructionLonger
+		}
+		return h.answer(ctx, a.chatID, question)
+	default:
+		slog.Warn("unknown callback action", "data", query.Data)
+		return h.ackCallback(ctx, query, "")
+	}
+}
+
+func (h *Handler) ackCallback(ctx context.Context, query *CallbackQuery, text string) error {
+	err := h.bot.AnswerCallbackQuery(ctx, query.ID, text)
+	if err != nil {
+		return fmt.Errorf("answer callback query: %w", err)
+	}
+	return nil
+}
+
+func (h *Handler) sendSources(ctx context.Context, a *answer, replyTo int64) error {
+	text := textNoSources
+	if len(a.sources) > 0 {
+		var sb strings.Builder
+		for i, src := range a.sources {
+			topic, _ := src.Metadata["topic"].(string)
+			if name, ok := src.Metadata["topic_name"].(string); ok {
+				topic = name
+			}
+			fmt.Fprintf(&sb, "%d. %s (%.2f)\n%s\n\n",
+				i+1, topic, src.Score, truncate(src.Content, sourcePreviewLength))
+		}
+		text, _ = splitMessage(sb.String(), maxMessageLength)
+	}
+
+	_, err := h.bot.SendMessage(ctx, a.chatID, text, WithReplyTo(replyTo))
+	if err != nil {
+		return fmt.Errorf("send sources: %w", err)
+	}
+	return h.sendPhotos(ctx, a.chatID, sourcePhotos(a.sources), replyTo)
+}
@@ -7,90 +7,425 @@ import (
 	"io"
 	"mime/multipart"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
-const apiUrl = "https://api.telegram.org/bot{{token}}/{{method_name}}"
+// DefaultAPIURL is the Telegram cloud Bot API.
+const DefaultAPIURL = "https://api.telegram.org"
+
+// Largest files bots can download from the cloud Bot API
+// and from a local Bot API server.
+const (
+	maxCloudFileSize = 20 << 20
+	maxLocalFileSize = 2000 << 20
+)
 
 const (
 	methodSendMessage = "sendMessage"
+	methodSendPhoto   = "sendPhoto"
 	methodEditMessage = "editMessageText"
-)
+	methodGetUpdates  = "getUpdates"
+	methodChatAction  = "sendChatAction"
+	methodGetMe       = "getMe"
+	methodSetCommands = "setMyCommands"
+	methodGetFile     = "getFile"
+	methodLogOut      = "logOut"
+	methodClose       = "close"
 
-func newUrl(token string, method string) string {
-	s := strings.Replace(apiUrl, "{{token}}", token, 1)
-	s = strings.Replace(s, "{{method_name}}", method, 1)
-	return s
-}
+	methodAnswerInlineQuery   = "answerInlineQuery"
+	methodAnswerCallbackQuery = "answerCallbackQuery"
+
+	methodSetWebhook     = "setWebhook"
+	methodGetWebhookInfo = "getWebhookInfo"
+	methodDeleteWebhook  = "deleteWebhook"
+)
 
 type Bot struct {
 	client *http.Client
-	token  string
+	// download is client without the overall timeout, which would cut
+	// off large files. Downloads are bounded by their context instead.
+	download *http.Client
+	apiURL   string
+	token    string
 }
 
-func NewBot(cli *http.Client, token string) *Bot {
+// NewBot creates a bot client. apiURL is the Bot API server,
+// an empty one means DefaultAPIURL.
+func NewBot(cli *http.Client, apiURL, token string) *Bot {
+	if apiURL == "" {
+		apiURL = DefaultAPIURL
+	}
+	download := *cli
+	download.Timeout = 0
 	return &Bot{
-		token:  token,
-		client: cli,
+		token:    token,
+		apiURL:   strings.TrimSuffix(apiURL, "/"),
+		client:   cli,
+		download: &download,
+	}
+}
+
+func (b *Bot) methodURL(method string) string {
+	return b.apiURL + "/bot" + b.token + "/" + method
+}
+
+// isLocal reports whether the bot talks to a local Bot API server.
+func (b *Bot) isLocal() bool {
+	return b.apiURL != DefaultAPIURL
+}
+
+// MaxFileSize is the size of the largest file the bot can download.
+func (b *Bot) MaxFileSize() int64 {
+	if b.isLocal() {
+		return maxLocalFileSize
+	}
+	return maxCloudFileSize
+}
+
+// MessageOption sets optional parameters of sent and edited messages.
+type MessageOption func(params map[string]string)
+
+const (
+	ParseModeHTML       = "HTML"
+	ParseModeMarkdownV2 = "MarkdownV2"
+)
+
+func WithParseMode(mode string) MessageOption {
+	return func(params map[string]string) {
+		params["parse_mode"] = mode
+	}
+}
+
+// WithReplyMarkup attaches an inline keyboard. Editing a message
+// without it removes the keyboard the message had.
+func WithReplyMarkup(markup *InlineKeyboardMarkup) MessageOption {
+	return func(params map[string]string) {
+		if markup == nil {
+			return
+		}
+		encoded, err := json.Marshal(markup)
+		if err != nil {
+			return
+		}
+		params["reply_markup"] = string(encoded)
+	}
+}
+
+func WithReplyTo(messageID int64) MessageOption {
+	return func(params map[string]string) {
+		params["reply_parameters"] = fmt.Sprintf(`{"message_id":%d,"allow_sending_without_reply":true}`, messageID)
+	}
+}
+
+// WithThread sends the message to a forum topic. Zero means
+// the chat itself, or the General topic of a forum.
+func WithThread(threadID int64) MessageOption {
+	return func(params map[string]string) {
+		if threadID != 0 {
+			params["message_thread_id"] = strconv.FormatInt(threadID, 10)
+		}
 	}
 }
 
 func (b *Bot) SendMessage(
 	ctx context.Context,
-	chatID, message string,
+	chatID int64,
+	message string,
+	opts ...MessageOption,
 ) (Message, error) {
-	r, w := io.Pipe()
-	m := multipart.NewWriter(w)
+	params := map[string]string{
+		"chat_id": strconv.FormatInt(chatID, 10),
+		"text":    message,
+	}
+	for _, opt := range opts {
+		opt(params)
+	}
 
-	go func() {
-		defer w.Close()
-		defer m.Close()
+	var msg Message
+	err := b.call(ctx, methodSendMessage, params, &msg)
+	if err != nil {
+		return Message{}, err
+	}
+	return msg, nil
+}
 
-		for k, v := range map[string]string{
-			"chat_id": chatID,
-			"text":    message,
-		} {
-			if err := m.WriteField(k, v); err != nil {
-				w.CloseWithError(err)
-				return
-			}
-		}
-	}()
+// SendPhoto sends a photo already stored on Telegram servers by its file_id.
+func (b *Bot) SendPhoto(
+	ctx context.Context,
+	chatID int64,
+	fileID string,
+	caption string,
+	opts ...MessageOption,
+) (Message, error) {
+	params := map[string]string{
+		"chat_id": strconv.FormatInt(chatID, 10),
+		"photo":   fileID,
+	}
+	if caption != "" {
+		params["caption"] = caption
+	}
+	for _, opt := range opts {
+		opt(params)
+	}
 
-	req, err := http.NewRequest(http.MethodPost, newUrl(b.token, methodSendMessage), r)
+	var msg Message
+	err := b.call(ctx, methodSendPhoto, params, &msg)
 	if err != nil {
-		return Message{}, fmt.Errorf("failed to create request: %w", err)
+		return Message{}, err
 	}
+	return msg, nil
+}
 
-	resp, err := b.client.Do(req)
+func (b *Bot) UpdateMessage(
+	ctx context.Context,
+	chatID, messageID int64,
+	message string,
+	opts ...MessageOption,
+) (Message, error) {
+	params := map[string]string{
+		"chat_id":    strconv.FormatInt(chatID, 10),
+		"message_id": strconv.FormatInt(messageID, 10),
+		"text":       message,
+	}
+	for _, opt := range opts {
+		opt(params)
+	}
+
+	var msg Message
+	err := b.call(ctx, methodEditMessage, params, &msg)
 	if err != nil {
-		return Message{}, fmt.Errorf("failed to send request: %w", err)
+		return Message{}, err
 	}
-	defer resp.Body.Close()
+	return msg, nil
+}
 
-	var apiResp APIResponse
-	err = json.NewDecoder(resp.Body).Decode(&apiResp)
+// GetMe returns the bot's own user.
+func (b *Bot) GetMe(ctx context.Context) (User, error) {
+	var me User
+	err := b.call(ctx, methodGetMe, nil, &me)
 	if err != nil {
-		return Message{}, fmt.Errorf("failed to decode response: %w", err)
+		return User{}, err
 	}
+	return me, nil
+}
 
-	if !apiResp.Ok {
-		return Message{}, fmt.Errorf("api error: %s", apiResp.Description)
+func (b *Bot) AnswerInlineQuery(
+	ctx context.Context,
+	queryID string,
+	results []InlineQueryResultArticle,
+	cacheTime time.Duration,
+) error {
+	encoded, err := json.Marshal(results)
+	if err != nil {
+		return fmt.Errorf("marshal results: %w", err)
 	}
+	return b.call(ctx, methodAnswerInlineQuery, map[string]string{
+		"inline_query_id": queryID,
+		"results":         string(encoded),
+		"cache_time":      strconv.Itoa(int(cacheTime.Seconds())),
+		// Answers come from the sender's own memories.
+		"is_personal": "true",
+	}, nil)
+}
+
+// AnswerCallbackQuery acknowledges a button press,
+// text is shown to the user as a notification if set.
+func (b *Bot) AnswerCallbackQuery(ctx context.Context, queryID, text string) error {
+	params := map[string]string{
+		"callback_query_id": queryID,
+	}
+	if text != "" {
+		params["text"] = text
+	}
+	return b.call(ctx, methodAnswerCallbackQuery, params, nil)
+}
 
-	msg := Message{}
-	err = json.Unmarshal(apiResp.Result, &message)
+// SetMyCommands publishes the command list shown to users whose
+// language is languageCode, or to everyone if it is empty.
+func (b *Bot) SetMyCommands(
+	ctx context.Context,
+	commands []BotCommand,
+	languageCode string,
+) error {
+	encoded, err := json.Marshal(commands)
 	if err != nil {
-		return Message{}, fmt.Errorf("failed to decode message: %w", err)
+		return fmt.Errorf("marshal commands: %w", err)
+	}
+	params := map[string]string{
+		"commands": string(encoded),
+	}
+	if languageCode != "" {
+		params["language_code"] = languageCode
 	}
+	return b.call(ctx, methodSetCommands, params, nil)
+}
 
-	return msg, nil
+// GetFile prepares a file for downloading.
+func (b *Bot) GetFile(ctx context.Context, fileID string) (File, error) {
+	var f File
+	err := b.call(ctx, methodGetFile, map[string]string{
+		"file_id": fileID,
+	}, &f)
+	if err != nil {
+		return File{}, err
+	}
+	return f, nil
 }
 
-func (b *Bot) UpdateMessage(
+// DownloadFile downloads a file returned by GetFile.
+// The caller must close the returned reader.
+//
+// A local Bot API server started with --local returns absolute paths
+// of files in its working directory, which must be mounted at the same
+// path here. Such files are read from the disk.
+func (b *Bot) DownloadFile(ctx context.Context, f File) (io.ReadCloser, error) {
+	if b.isLocal() && filepath.IsAbs(f.FilePath) {
+		file, err := os.Open(f.FilePath)
+		if err != nil {
+			return nil, fmt.Errorf("open local file: %w", err)
+		}
+		return file, nil
+	}
+
+	u := b.apiURL + "/file/bot" + b.token + "/" + f.FilePath
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := b.download.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("download file: %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// LogOut logs the bot out of the cloud Bot API server, which
+// must be done before moving the bot to a local server.
+func (b *Bot) LogOut(ctx context.Context) error {
+	return b.call(ctx, methodLogOut, nil, nil)
+}
+
+// Close closes the bot instance on a local server, which must be
+// done before moving the bot to another local server.
+func (b *Bot) Close(ctx context.Context) error {
+	return b.call(ctx, methodClose, nil, nil)
+}
+
+const ChatActionTyping = "typing"
+
+// SendChatAction shows the action in the chat. Only WithThread applies to it.
+func (b *Bot) SendChatAction(ctx context.Context, chatID int64, action string, opts ...MessageOption) error {
+	params := map[string]string{
+		"chat_id": strconv.FormatInt(chatID, 10),
+		"action":  action,
+	}
+	for _, opt := range opts {
+		opt(params)
+	}
+	return b.call(ctx, methodChatAction, params, nil)
+}
+
+// GetUpdates long-polls Telegram for updates starting from offset.
+func (b *Bot) GetUpdates(
 	ctx context.Context,
-	chatID, messageID, message string,
-) (Message, error) {
+	offset int64,
+	timeout time.Duration,
+) ([]Update, error) {
+	var updates []Update
+	err := b.call(ctx, methodGetUpdates, map[string]string{
+		"offset":  strconv.FormatInt(offset, 10),
+		"timeout": strconv.Itoa(int(timeout.Seconds())),
+	}, &updates)
+	if err != nil {
+		return nil, err
+	}
+	return updates, nil
+}
+
+// SetWebhook registers cfg.URL as the webhook. Certificate is uploaded
+// only when cfg.CertificatePath is set.
+func (b *Bot) SetWebhook(ctx context.Context, cfg WebhookConfig) error {
+	params := map[string]string{
+		"url":                  cfg.URL,
+		"drop_pending_updates": strconv.FormatBool(cfg.DropPendingUpdates),
+	}
+	if cfg.SecretToken != "" {
+		params["secret_token"] = cfg.SecretToken
+	}
+	if cfg.MaxConnections > 0 {
+		params["max_connections"] = strconv.Itoa(cfg.MaxConnections)
+	}
+	if len(cfg.AllowedUpdates) > 0 {
+		allowed, err := json.Marshal(cfg.AllowedUpdates)
+		if err != nil {
+			return fmt.Errorf("marshal allowed updates: %w", err)
+		}
+		params["allowed_updates"] = string(allowed)
+	}
+
+	var files map[string]inputFile
+	if cfg.CertificatePath != "" {
+		f, err := os.Open(cfg.CertificatePath)
+		if err != nil {
+			return fmt.Errorf("open certificate: %w", err)
+		}
+		defer f.Close()
+		files = map[string]inputFile{
+			"certificate": {name: filepath.Base(cfg.CertificatePath), r: f},
+		}
+	}
+
+	return b.callWithFiles(ctx, methodSetWebhook, params, files, nil)
+}
+
+func (b *Bot) GetWebhookInfo(ctx context.Context) (WebhookInfo, error) {
+	var info WebhookInfo
+	err := b.call(ctx, methodGetWebhookInfo, nil, &info)
+	if err != nil {
+		return WebhookInfo{}, err
+	}
+	return info, nil
+}
+
+func (b *Bot) DeleteWebhook(ctx context.Context, dropPendingUpdates bool) error {
+	return b.call(ctx, methodDeleteWebhook, map[string]string{
+		"drop_pending_updates": strconv.FormatBool(dropPendingUpdates),
+	}, nil)
+}
+
+type inputFile struct {
+	name string
+	r    io.Reader
+}
+
+// call sends params as multipart form to the given method
+// and decodes the result field of the response into result.
+func (b *Bot) call(
+	ctx context.Context,
+	method string,
+	params map[string]string,
+	result any,
+) error {
+	return b.callWithFiles(ctx, method, params, nil, result)
+}
+
+// callWithFiles is call that additionally uploads files
+// as multipart file parts keyed by field name.
+func (b *Bot) callWithFiles(
+	ctx context.Context,
+	method string,
+	params map[string]string,
+	files map[string]inputFile,
+	result any,
+) error {
 	r, w := io.Pipe()
 	m := multipart.NewWriter(w)
 
@@ -98,44 +433,60 @@ func (b *Bot) UpdateMessage(
 		defer w.Close()
 		defer m.Close()
 
-		for k, v := range map[string]string{
-			"chat_id":    chatID,
-			"text":       message,
-			"message_id": messageID,
-		} {
+		for k, v := range params {
 			if err := m.WriteField(k, v); err != nil {
 				w.CloseWithError(err)
 				return
 			}
 		}
+		for k, f := range files {
+			part, err := m.CreateFormFile(k, f.name)
+			if err != nil {
+				w.CloseWithError(err)
+				return
+			}
+			if _, err = io.Copy(part, f.r); err != nil {
+				w.CloseWithError(err)
+				return
+			}
+		}
 	}()
 
-	req, err := http.NewRequest(http.MethodPost, newUrl(b.token, methodEditMessage), r)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.methodURL(method), r)
 	if err != nil {
-		return Message{}, fmt.Errorf("failed to create request: %w", err)
+		return fmt.Errorf("failed to create request: %w", err)
 	}
+	req.Header.Set("Content-Type", m.FormDataContentType())
 
 	resp, err := b.client.Do(req)
 	if err != nil {
-		return Message{}, fmt.Errorf("failed to send request: %w", err)
+		return fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	var apiResp APIResponse
 	err = json.NewDecoder(resp.Body).Decode(&apiResp)
 	if err != nil {
-		return Message{}, fmt.Errorf("failed to decode response: %w", err)
+		return fmt.Errorf("failed to decode response: %w", err)
 	}
 
 	if !apiResp.Ok {
-		return Message{}, fmt.Errorf("api error: %s", apiResp.Description)
+		apiErr := &APIError{
+			Code:        apiResp.ErrorCode,
+			Description: apiResp.Description,
+		}
+		if apiResp.Parameters != nil {
+			apiErr.RetryAfter = time.Duration(apiResp.Parameters.RetryAfter) * time.Second
+		}
+		return apiErr
 	}
 
-	msg := Message{}
-	err = json.Unmarshal(apiResp.Result, &message)
+	if result == nil {
+		return nil
+	}
+	err = json.Unmarshal(apiResp.Result, result)
 	if err != nil {
-		return Message{}, fmt.Errorf("failed to decode message: %w", err)
+		return fmt.Errorf("failed to decode result: %w", err)
 	}
-
-	return msg, nil
+	return nil
 }
@@ -0,0 +1,95 @@
+package telegram
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBotAPIURL(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		switch r.URL.Path {
+		case "/botTOKEN/getFile":
+			_, _ = w.Write([]byte(`{"ok":true,"result":{"file_id":"f1","file_unique_id":"u1","file_path":"documents/file_1.txt"}}`))
+		case "/file/botTOKEN/documents/file_1.txt":
+			_, _ = w.Write([]byte("remote"))
+		default:
+			_, _ = w.Write([]byte(`{"ok":true,"result":true}`))
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	b := NewBot(srv.Client(), srv.URL+"/", "TOKEN")
+	require.True(t, b.isLocal())
+	require.Equal(t, int64(maxLocalFileSize), b.MaxFileSize())
+
+	f, err := b.GetFile(ctx, "f1")
+	require.NoError(t, err)
+	body, err := b.DownloadFile(ctx, f)
+	require.NoError(t, err)
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	require.Equal(t, "remote", string(data))
+
+	require.NoError(t, b.LogOut(ctx))
+	require.NoError(t, b.Close(ctx))
+	require.Equal(t, []string{
+		"/botTOKEN/getFile",
+		"/file/botTOKEN/documents/file_1.txt",
+		"/botTOKEN/logOut",
+		"/botTOKEN/close",
+	}, paths)
+}
+
+func TestBotLocalFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "voice", "file_0.oga")
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte("local"), 0o644))
+
+	b := NewBot(http.DefaultClient, "http://localhost:8081", "TOKEN")
+	body, err := b.DownloadFile(context.Background(), File{FilePath: path})
+	require.NoError(t, err)
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	require.Equal(t, "local", string(data))
+
+	cloud := NewBot(http.DefaultClient, "", "TOKEN")
+	require.False(t, cloud.isLocal())
+	require.Equal(t, int64(maxCloudFileSize), cloud.MaxFileSize())
+}
+
+func TestBotDownloadTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		_, _ = w.Write([]byte("slow"))
+	}))
+	defer srv.Close()
+
+	cli := srv.Client()
+	cli.Timeout = 50 * time.Millisecond
+	b := NewBot(cli, srv.URL, "TOKEN")
+
+	// The client timeout applies to API calls, not to downloads.
+	_, err := b.GetMe(context.Background())
+	require.Error(t, err)
+
+	body, err := b.DownloadFile(context.Background(), File{FilePath: "documents/file_1.pdf"})
+	require.NoError(t, err)
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	require.Equal(t, "slow", string(data))
+}
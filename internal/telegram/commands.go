@@ -0,0 +1,263 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"tgpt/internal/models"
+)
+
+// defaultLanguage is used for users whose language has no translation.
+const defaultLanguage = "en"
+
+const (
+	textUnknownCommand = "Unknown command, see /help."
+	textEmptyQuestion  = "Ask a question after the command, e.g. /ask #travel where did I go in spring?"
+)
+
+var textGreeting = map[string]string{
+	"en": "Hi! Send me anything and I will remember it. Use #hashtags to sort messages into topics and ask me about them later.",
+	"ru": "Привет! Присылай что угодно, я всё запомню. Используй #хэштеги, чтобы разложить сообщения по темам, и спрашивай меня о них потом.",
+}
+
+var textCommandsTitle = map[string]string{
+	"en": "Commands:",
+	"ru": "Команды:",
+}
+
+// command is a bot command. It is triggered by /name in any
+// language, by /name@bot in groups and by !alias in text.
+type command struct {
+	name string
+	// aliases are alternative names per language.
+	aliases map[string][]string
+	// voice are the words that trigger the command when a voice
+	// message starts with them. Commands without any can't be spoken.
+	voice []string
+	args  string
+	// description is the help text per language.
+	description map[string]string
+	handle      func(ctx context.Context, message *Message, args string) error
+}
+
+type commandRegistry struct {
+	commands []*command
+	byName   map[string]*command
+	byVoice  map[string]*command
+}
+
+func newCommandRegistry(commands ...*command) *commandRegistry {
+	r := &commandRegistry{byName: map[string]*command{}, byVoice: map[string]*command{}}
+	for _, c := range commands {
+		r.commands = append(r.commands, c)
+		r.byName[c.name] = c
+		for _, aliases := range c.aliases {
+			for _, alias := range aliases {
+				r.byName[strings.ToLower(alias)] = c
+			}
+		}
+		for _, word := range c.voice {
+			r.byVoice[strings.ToLower(word)] = c
+		}
+	}
+	return r
+}
+
+// parse finds the command the text starts with. ok is false if the text
+// is not a command, cmd is nil if it is an unknown command or a command
+// addressed to another bot. Text starting with ! is a command only if
+// it names a known command, so notes like "!!! deadline moved" are not.
+func (r *commandRegistry) parse(text, botUsername string) (cmd *command, args string, ok bool) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "/") && !strings.HasPrefix(text, "!") {
+		return nil, "", false
+	}
+
+	first, args, _ := strings.Cut(text, " ")
+	if i := strings.IndexByte(first, '\n'); i >= 0 {
+		first, args = first[:i], first[i+1:]+" "+args
+	}
+	name, bot, _ := strings.Cut(first[1:], "@")
+	cmd = r.byName[strings.ToLower(name)]
+	if text[0] == '!' && cmd == nil {
+		return nil, "", false
+	}
+	if bot != "" && !strings.EqualFold(bot, botUsername) {
+		return nil, "", true
+	}
+	return cmd, strings.TrimSpace(args), true
+}
+
+// languages returns every language commands are translated to.
+func (r *commandRegistry) languages() []string {
+	var langs []string
+	for _, c := range r.commands {
+		for lang := range c.description {
+			if !slices.Contains(langs, lang) {
+				langs = append(langs, lang)
+			}
+		}
+	}
+	slices.Sort(langs)
+	return langs
+}
+
+func (r *commandRegistry) botCommands(lang string) []BotCommand {
+	commands := make([]BotCommand, 0, len(r.commands))
+	for _, c := range r.commands {
+		commands = append(commands, BotCommand{
+			Command:     c.name,
+			Description: localized(c.description, lang),
+		})
+	}
+	return commands
+}
+
+func (r *commandRegistry) help(lang string) string {
+	var sb strings.Builder
+	sb.WriteString(localized(textCommandsTitle, lang) + "\n")
+	for _, c := range r.commands {
+		sb.WriteString("/" + c.name)
+		if c.args != "" {
+			sb.WriteString(" " + c.args)
+		}
+		sb.WriteString(" — " + localized(c.description, lang))
+
+		var aliases []string
+		for _, a := range c.aliases[lang] {
+			aliases = append(aliases, "!"+a)
+		}
+		if len(aliases) > 0 {
+			sb.WriteString(" (" + strings.Join(aliases, ", ") + ")")
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func localized(texts map[string]string, lang string) string {
+	if text, ok := texts[lang]; ok {
+		return text
+	}
+	return texts[defaultLanguage]
+}
+
+// PublishCommands registers the commands through setMyCommands,
+// translated to every language they have descriptions in.
+func (h *Handler) PublishCommands(ctx context.Context) error {
+	err := h.bot.SetMyCommands(ctx, h.commands.botCommands(defaultLanguage), "")
+	if err != nil {
+		return fmt.Errorf("set default commands: %w", err)
+	}
+	for _, lang := range h.commands.languages() {
+		err = h.bot.SetMyCommands(ctx, h.commands.botCommands(lang), lang)
+		if err != nil {
+			return fmt.Errorf("set %s commands: %w", lang, err)
+		}
+	}
+	return nil
+}
+
+func (h *Handler) newCommands() *commandRegistry {
+	return newCommandRegistry(
+		&command{
+			name: "ask",
+			aliases: map[string][]string{
+				"en": {"bro"},
+				"ru": {"бро", "спроси"},
+			},
+			voice: []string{"bro", "бро"},
+			args:  "[#topic] question",
+			description: map[string]string{
+				"en": "Ask about your memories",
+				"ru": "Спросить о сохранённом",
+			},
+			handle: h.commandAsk,
+		},
+		&command{
+			name: "forget",
+			aliases: map[string][]string{
+				"ru": {"забудь"},
+			},
+			args: "[#topic] [since:DATE] [until:DATE] | all",
+			description: map[string]string{
+				"en": "Delete memories, reply to a message to delete it",
+				"ru": "Удалить сохранённое, ответом на сообщение — удалить его",
+			},
+			handle: h.commandForget,
+		},
+		&command{
+			name: "stop",
+			description: map[string]string{
+				"en": "Stop the answer being generated",
+				"ru": "Остановить генерацию ответа",
+			},
+			handle: h.commandStop,
+		},
+		&command{
+			name: "help",
+			description: map[string]string{
+				"en": "List commands",
+				"ru": "Список команд",
+			},
+			handle: h.commandHelp,
+		},
+		&command{
+			name: "start",
+			description: map[string]string{
+				"en": "Start using the bot",
+				"ru": "Начать работу с ботом",
+			},
+			handle: h.commandStart,
+		},
+	)
+}
+
+func (h *Handler) commandAsk(ctx context.Context, message *Message, args string) error {
+	if args == "" {
+		return h.reply(ctx, message, textEmptyQuestion)
+	}
+
+	question := *message
+	question.Text = args
+	businessMessage := question.toBuisnessModel()
+	businessMessage.Text = h.stripMention(businessMessage.Text)
+	businessMessage.Command = models.CommandRecall
+	message.scopeToDocument(&businessMessage)
+	return h.answer(ctx, message.Chat.ID, businessMessage)
+}
+
+func (h *Handler) commandStop(ctx context.Context, message *Message, _ string) error {
+	if h.generations.stop(message.Chat.ID) {
+		return nil
+	}
+	return h.reply(ctx, message, textNothingToStop)
+}
+
+func (h *Handler) commandHelp(ctx context.Context, message *Message, _ string) error {
+	return h.reply(ctx, message, h.commands.help(message.languageCode()))
+}
+
+func (h *Handler) commandStart(ctx context.Context, message *Message, _ string) error {
+	lang := message.languageCode()
+	return h.reply(ctx, message, localized(textGreeting, lang)+"\n\n"+h.commands.help(lang))
+}
+
+func (h *Handler) reply(ctx context.Context, message *Message, text string) error {
+	_, err := h.bot.SendMessage(ctx, message.Chat.ID, text, WithReplyTo(message.MessageID))
+	if err != nil {
+		return fmt.Errorf("send message: %w", err)
+	}
+	return nil
+}
+
+// languageCode returns the sender's language without the region.
+func (m Message) languageCode() string {
+	if m.From == nil || m.From.LanguageCode == "" {
+		return defaultLanguage
+	}
+	lang, _, _ := strings.Cut(m.From.LanguageCode, "-")
+	return lang
+}
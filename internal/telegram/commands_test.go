@@ -0,0 +1,45 @@
+package telegram
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandRegistry(t *testing.T) {
+	h := &Handler{}
+	r := h.newCommands()
+
+	for _, tc := range []struct {
+		text    string
+		command string
+		args    string
+		ok      bool
+	}{
+		{"just text", "", "", false},
+		{"/ask #travel where?", "ask", "#travel where?", true},
+		{"/ask@tgpt_bot where?", "ask", "where?", true},
+		{"/ask@other_bot where?", "", "", true},
+		{"!bro where?", "ask", "where?", true},
+		{"!БРО где?", "ask", "где?", true},
+		{"/help", "help", "", true},
+		{"/nope", "", "", true},
+		{"!!! deadline moved", "", "", false},
+		{"!nope", "", "", false},
+	} {
+		t.Run(tc.text, func(t *testing.T) {
+			cmd, args, ok := r.parse(tc.text, "tgpt_bot")
+			require.Equal(t, tc.ok, ok)
+			if tc.command == "" {
+				require.Nil(t, cmd)
+				return
+			}
+			require.Equal(t, tc.command, cmd.name)
+			require.Equal(t, tc.args, args)
+		})
+	}
+
+	require.Equal(t, []string{"en", "ru"}, r.languages())
+	require.Contains(t, r.help("ru"), "/ask [#topic] question — Спросить о сохранённом (!бро, !спроси)")
+	require.Contains(t, r.help("de"), "/help — List commands")
+}
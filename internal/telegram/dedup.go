@@ -0,0 +1,117 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Deduplicator drops updates whose update_id was already handed over
+// within ttl. Seen ids are optionally persisted to a file, so
+// redeliveries after a restart are dropped as well.
+type Deduplicator struct {
+	next updateHandler
+	ttl  time.Duration
+	path string
+
+	mu   sync.Mutex
+	seen map[int64]time.Time
+
+	now func() time.Time
+}
+
+// NewDeduplicator creates a deduplicator in front of next.
+// If path is empty seen ids are kept in memory only.
+func NewDeduplicator(next updateHandler, ttl time.Duration, path string) (*Deduplicator, error) {
+	d := &Deduplicator{
+		next: next,
+		ttl:  ttl,
+		path: path,
+		seen: map[int64]time.Time{},
+		now:  time.Now,
+	}
+	if path == "" {
+		return d, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+	err = json.Unmarshal(b, &d.seen)
+	if err != nil {
+		return nil, fmt.Errorf("decode seen updates: %w", err)
+	}
+	return d, nil
+}
+
+// HandleUpdate passes the update to next unless it was seen before.
+// The update counts as seen only if next accepted it, so a rejected
+// update is processed when Telegram redelivers it.
+func (d *Deduplicator) HandleUpdate(ctx context.Context, update Update) error {
+	if !d.reserve(update.UpdateID) {
+		slog.Info("skip duplicate update", "update_id", update.UpdateID)
+		return nil
+	}
+
+	err := d.next.HandleUpdate(ctx, update)
+	if err != nil {
+		d.release(update.UpdateID)
+		return err
+	}
+
+	d.persist()
+	return nil
+}
+
+func (d *Deduplicator) reserve(updateID int64) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	for id, expires := range d.seen {
+		if now.After(expires) {
+			delete(d.seen, id)
+		}
+	}
+
+	if _, ok := d.seen[updateID]; ok {
+		return false
+	}
+	d.seen[updateID] = now.Add(d.ttl)
+	return true
+}
+
+func (d *Deduplicator) release(updateID int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.seen, updateID)
+}
+
+func (d *Deduplicator) persist() {
+	if d.path == "" {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	b, err := json.Marshal(d.seen)
+	if err != nil {
+		slog.Error("encode seen updates", "error", err.Error())
+		return
+	}
+
+	err = writeFileAtomic(d.path, b)
+	if err != nil {
+		slog.Error("save seen updates", "error", err.Error())
+	}
+}
@@ -0,0 +1,73 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type countingHandler struct {
+	calls map[int64]int
+	err   error
+}
+
+func (c *countingHandler) HandleUpdate(_ context.Context, update Update) error {
+	c.calls[update.UpdateID]++
+	return c.err
+}
+
+func TestDeduplicator(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("drops redelivered updates", func(t *testing.T) {
+		next := &countingHandler{calls: map[int64]int{}}
+		d, err := NewDeduplicator(next, time.Hour, "")
+		require.NoError(t, err)
+
+		require.NoError(t, d.HandleUpdate(ctx, Update{UpdateID: 1}))
+		require.NoError(t, d.HandleUpdate(ctx, Update{UpdateID: 1}))
+		require.Equal(t, 1, next.calls[1])
+	})
+
+	t.Run("forgets after ttl", func(t *testing.T) {
+		next := &countingHandler{calls: map[int64]int{}}
+		d, err := NewDeduplicator(next, time.Minute, "")
+		require.NoError(t, err)
+		now := time.Now()
+		d.now = func() time.Time { return now }
+
+		require.NoError(t, d.HandleUpdate(ctx, Update{UpdateID: 1}))
+		now = now.Add(2 * time.Minute)
+		require.NoError(t, d.HandleUpdate(ctx, Update{UpdateID: 1}))
+		require.Equal(t, 2, next.calls[1])
+	})
+
+	t.Run("retries rejected updates", func(t *testing.T) {
+		next := &countingHandler{calls: map[int64]int{}, err: errors.New("full")}
+		d, err := NewDeduplicator(next, time.Hour, "")
+		require.NoError(t, err)
+
+		require.Error(t, d.HandleUpdate(ctx, Update{UpdateID: 1}))
+		next.err = nil
+		require.NoError(t, d.HandleUpdate(ctx, Update{UpdateID: 1}))
+		require.Equal(t, 2, next.calls[1])
+	})
+
+	t.Run("survives restart", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "seen.json")
+		next := &countingHandler{calls: map[int64]int{}}
+
+		d, err := NewDeduplicator(next, time.Hour, path)
+		require.NoError(t, err)
+		require.NoError(t, d.HandleUpdate(ctx, Update{UpdateID: 7}))
+
+		d, err = NewDeduplicator(next, time.Hour, path)
+		require.NoError(t, err)
+		require.NoError(t, d.HandleUpdate(ctx, Update{UpdateID: 7}))
+		require.Equal(t, 1, next.calls[7])
+	})
+}
@@ -0,0 +1,191 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log/slog"
+	"sync"
+)
+
+var ErrQueueFull = errors.New("update queue is full")
+
+var dispatcherMetrics = expvar.NewMap("telegram_dispatcher")
+
+const (
+	metricQueueDepth = "queue_depth"
+	metricEnqueued   = "enqueued"
+	metricRejected   = "rejected"
+	metricProcessed  = "processed"
+	metricFailed     = "failed"
+	metricDropped    = "dropped"
+)
+
+// Dispatcher processes updates asynchronously on a fixed number of
+// workers. Updates of the same chat always go to the same worker,
+// so they are handled in the order they were received.
+type Dispatcher struct {
+	handler updateHandler
+	queues  []chan Update
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu sync.Mutex
+	// dropped are the updates cut short by Close. Their offset and
+	// dedup entries are already stored, so they are never redelivered.
+	dropped []int64
+}
+
+func NewDispatcher(handler updateHandler, workers, queueSize int) *Dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+	queues := make([]chan Update, workers)
+	for i := range queues {
+		queues[i] = make(chan Update, queueSize)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Dispatcher{
+		handler: handler,
+		queues:  queues,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// Start launches the workers.
+func (d *Dispatcher) Start() {
+	for _, q := range d.queues {
+		d.wg.Add(1)
+		go d.work(q)
+	}
+}
+
+// HandleUpdate enqueues the update, blocking while the chat's
+// queue is full. It returns ErrQueueFull if ctx is done first.
+func (d *Dispatcher) HandleUpdate(ctx context.Context, update Update) error {
+	if update.isStop() {
+		// Stop requests must not wait behind the generation they stop.
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			d.process(update)
+		}()
+		return nil
+	}
+
+	q := d.queues[d.shard(update)]
+	select {
+	case q <- update:
+		dispatcherMetrics.Add(metricQueueDepth, 1)
+		dispatcherMetrics.Add(metricEnqueued, 1)
+		return nil
+	case <-ctx.Done():
+		dispatcherMetrics.Add(metricRejected, 1)
+		return ErrQueueFull
+	}
+}
+
+// Close waits until the queued updates are processed. It must be called
+// after the producers are stopped. When ctx is done first, in-flight
+// processing is cancelled and the rest of the queue is dropped. It returns
+// the ids of the updates that were not processed, which are also logged.
+func (d *Dispatcher) Close(ctx context.Context) []int64 {
+	for _, q := range d.queues {
+		close(q)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Warn("dispatcher did not drain in time")
+		d.cancel()
+		<-done
+	}
+	d.cancel()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.dropped) > 0 {
+		slog.Error("updates dropped on shutdown", "update_ids", d.dropped)
+	}
+	return d.dropped
+}
+
+func (d *Dispatcher) work(q <-chan Update) {
+	defer d.wg.Done()
+	for update := range q {
+		dispatcherMetrics.Add(metricQueueDepth, -1)
+		if d.ctx.Err() != nil {
+			d.drop(update)
+			continue
+		}
+		d.process(update)
+	}
+}
+
+func (d *Dispatcher) drop(update Update) {
+	dispatcherMetrics.Add(metricDropped, 1)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dropped = append(d.dropped, update.UpdateID)
+}
+
+func (d *Dispatcher) process(update Update) {
+	err := d.handler.HandleUpdate(d.ctx, update)
+	if err != nil && d.ctx.Err() != nil {
+		d.drop(update)
+		return
+	}
+	if err != nil {
+		dispatcherMetrics.Add(metricFailed, 1)
+		slog.Error("handle update",
+			"error", err.Error(),
+			"update_id", update.UpdateID,
+		)
+		return
+	}
+	dispatcherMetrics.Add(metricProcessed, 1)
+}
+
+func (d *Dispatcher) shard(update Update) int {
+	id := update.chatID()
+	if id < 0 {
+		id = -id
+	}
+	return int(id % int64(len(d.queues)))
+}
+
+// chatID returns the chat the update belongs to, or
+// the sender for updates that are not bound to a chat.
+func (u Update) chatID() int64 {
+	switch {
+	case u.Message != nil:
+		return u.Message.Chat.ID
+	case u.EditedMessage != nil:
+		return u.EditedMessage.Chat.ID
+	case u.ChannelPost != nil:
+		return u.ChannelPost.Chat.ID
+	case u.EditedChannelPost != nil:
+		return u.EditedChannelPost.Chat.ID
+	case u.CallbackQuery != nil && u.CallbackQuery.Message != nil:
+		return u.CallbackQuery.Message.Chat.ID
+	case u.CallbackQuery != nil:
+		return u.CallbackQuery.From.ID
+	case u.InlineQuery != nil:
+		return u.InlineQuery.From.ID
+	case u.MyChatMember != nil:
+		return u.MyChatMember.Chat.ID
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,89 @@
+package telegram
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type recordingHandler struct {
+	mu      sync.Mutex
+	handled map[int64][]int64
+}
+
+func (r *recordingHandler) HandleUpdate(_ context.Context, update Update) error {
+	time.Sleep(time.Millisecond)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	chatID := update.chatID()
+	r.handled[chatID] = append(r.handled[chatID], update.UpdateID)
+	return nil
+}
+
+func TestDispatcher(t *testing.T) {
+	t.Run("keeps order per chat", func(t *testing.T) {
+		h := &recordingHandler{handled: map[int64][]int64{}}
+		d := NewDispatcher(h, 3, 2)
+		d.Start()
+
+		ctx := context.Background()
+		var want = map[int64][]int64{}
+		for i := int64(1); i <= 30; i++ {
+			chatID := i % 4
+			want[chatID] = append(want[chatID], i)
+			err := d.HandleUpdate(ctx, Update{
+				UpdateID: i,
+				Message:  &Message{Chat: Chat{ID: -chatID}},
+			})
+			require.NoError(t, err)
+		}
+		d.Close(ctx)
+
+		for chatID, ids := range want {
+			require.Equal(t, ids, h.handled[-chatID])
+		}
+	})
+
+	t.Run("rejects when full", func(t *testing.T) {
+		h := &recordingHandler{handled: map[int64][]int64{}}
+		d := NewDispatcher(h, 1, 1)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		require.NoError(t, d.HandleUpdate(ctx, Update{UpdateID: 1}))
+		require.ErrorIs(t, d.HandleUpdate(ctx, Update{UpdateID: 2}), ErrQueueFull)
+
+		d.Start()
+		d.Close(context.Background())
+		require.Equal(t, []int64{1}, h.handled[0])
+	})
+
+	t.Run("reports updates dropped on close", func(t *testing.T) {
+		block := make(chan struct{})
+		h := updateHandlerFunc(func(ctx context.Context, _ Update) error {
+			close(block)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		d := NewDispatcher(h, 1, 3)
+		d.Start()
+
+		for i := int64(1); i <= 3; i++ {
+			require.NoError(t, d.HandleUpdate(context.Background(), Update{UpdateID: i}))
+		}
+		<-block
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		require.Equal(t, []int64{1, 2, 3}, d.Close(ctx))
+	})
+}
+
+type updateHandlerFunc func(ctx context.Context, update Update) error
+
+func (f updateHandlerFunc) HandleUpdate(ctx context.Context, update Update) error {
+	return f(ctx, update)
+}
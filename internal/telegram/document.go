@@ -0,0 +1,175 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"tgpt/internal/chat"
+	"tgpt/internal/models"
+)
+
+const (
+	textDocumentSaved       = "saved %s, %d chunks. Reply to the file to ask about it."
+	textDocumentEmpty       = "%s has no text to remember"
+	textDocumentTooLarge    = "⚠️ Files over %d MB are not supported."
+	textDocumentUnsupported = "⚠️ Only txt, md, html and pdf files are supported."
+	textDocumentFailed      = "⚠️ Could not process the file."
+)
+
+// defaultMaxDocumentSize is the size of the largest document the
+// bot processes unless configured otherwise. Text extracted from
+// a document is held in memory, so it is less than what the Bot API
+// server allows.
+const defaultMaxDocumentSize = 50 << 20
+
+// downloadTimeout bounds a single file download.
+const downloadTimeout = 30 * time.Minute
+
+// saveFile stores the text of an uploaded document, the caption sets its topic.
+func (h *Handler) saveFile(ctx context.Context, message *Message) error {
+	doc := message.Document
+	addressed := h.isAddressed(message)
+	notify := func(text string) error {
+		if !addressed {
+			return nil
+		}
+		return h.reply(ctx, message, text)
+	}
+
+	maxSize := min(h.bot.MaxFileSize(), h.maxDocumentSize)
+	if doc.FileSize > maxSize {
+		return notify(fmt.Sprintf(textDocumentTooLarge, maxSize>>20))
+	}
+
+	err := h.bot.SendChatAction(ctx, message.Chat.ID, ChatActionTyping, WithThread(message.threadID()))
+	if err != nil {
+		slog.Warn("send chat action", "error", err.Error(), "chat_id", message.Chat.ID)
+	}
+
+	content, size, err := h.downloadTempFile(ctx, doc.FileID, maxSize)
+	if err != nil {
+		_ = notify(textDocumentFailed)
+		return err
+	}
+	defer removeTempFile(content)
+
+	withCaption := *message
+	withCaption.Text = h.stripMention(message.Caption)
+	businessMessage := withCaption.toBuisnessModel()
+	businessMessage.Source = models.SourceDocument
+	businessMessage.FileID = doc.FileID
+	businessMessage.DocumentID = doc.FileUniqueID
+
+	name := doc.FileName
+	if name == "" {
+		name = doc.FileUniqueID
+	}
+	n, err := h.chatService.SaveFile(ctx, businessMessage, chat.File{
+		Name:     name,
+		MimeType: doc.MimeType,
+		Content:  content,
+		Size:     size,
+	})
+	switch {
+	case errors.Is(err, chat.ErrUnsupportedFile):
+		return notify(textDocumentUnsupported)
+	case err != nil:
+		_ = notify(textDocumentFailed)
+		return fmt.Errorf("save file: %w", err)
+	case n == 0:
+		return notify(fmt.Sprintf(textDocumentEmpty, name))
+	default:
+		return notify(fmt.Sprintf(textDocumentSaved, name, n))
+	}
+}
+
+// repliedDocument returns the uploaded document the message replies to.
+// Questions asked this way are answered from that document only.
+func (m Message) repliedDocument() *Document {
+	r := m.repliedMessage()
+	if r == nil {
+		return nil
+	}
+	return r.Document
+}
+
+// scopeToDocument turns the message into a question about
+// the document it replies to, if it replies to one.
+func (m Message) scopeToDocument(businessMessage *models.Message) {
+	doc := m.repliedDocument()
+	if doc == nil {
+		return
+	}
+	businessMessage.Command = models.CommandRecall
+	businessMessage.DocumentID = doc.FileUniqueID
+}
+
+// downloadFile reads the whole file into memory,
+// it fails if the file is over limit bytes.
+func (h *Handler) downloadFile(ctx context.Context, fileID string, limit int64) ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := h.copyFile(ctx, &buf, fileID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// downloadTempFile streams the file to a temporary file, so large
+// files are not held in memory. It returns the file, rewound to the
+// start, and its size. The file must be disposed of with removeTempFile.
+func (h *Handler) downloadTempFile(ctx context.Context, fileID string, limit int64) (*os.File, int64, error) {
+	f, err := os.CreateTemp("", "tgpt-file-*")
+	if err != nil {
+		return nil, 0, fmt.Errorf("create temp file: %w", err)
+	}
+	n, err := h.copyFile(ctx, f, fileID, limit)
+	if err != nil {
+		removeTempFile(f)
+		return nil, 0, err
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		removeTempFile(f)
+		return nil, 0, fmt.Errorf("rewind temp file: %w", err)
+	}
+	return f, n, nil
+}
+
+func removeTempFile(f *os.File) {
+	_ = f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		slog.Warn("remove temp file", "error", err.Error(), "path", f.Name())
+	}
+}
+
+// copyFile downloads the file into w, it fails if the file is over limit bytes.
+func (h *Handler) copyFile(ctx context.Context, w io.Writer, fileID string, limit int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, downloadTimeout)
+	defer cancel()
+
+	f, err := h.bot.GetFile(ctx, fileID)
+	if err != nil {
+		return 0, fmt.Errorf("get file: %w", err)
+	}
+	body, err := h.bot.DownloadFile(ctx, f)
+	if err != nil {
+		return 0, fmt.Errorf("download file: %w", err)
+	}
+	defer body.Close()
+
+	n, err := io.Copy(w, io.LimitReader(body, limit+1))
+	if err != nil {
+		return 0, fmt.Errorf("read file: %w", err)
+	}
+	if n > limit {
+		return 0, fmt.Errorf("file is over %d bytes", limit)
+	}
+	return n, nil
+}
@@ -0,0 +1,569 @@
+package telegram_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"tgpt/internal/chat"
+	"tgpt/internal/models"
+	"tgpt/internal/telegram"
+	"tgpt/internal/telegram/telegramtest"
+)
+
+const (
+	userID      = 184467440
+	groupID     = -1001234567890
+	secretToken = "secret"
+)
+
+// fakeChat remembers saved messages and answers questions with a fixed
+// text streamed in chunks.
+type fakeChat struct {
+	mu       sync.Mutex
+	saved    []models.Message
+	asked    []models.Message
+	files    []string
+	recalled []models.Message
+	forgets  []chat.Forget
+	edited   []models.Message
+	answer   []string
+	// err fails questions after the answer is streamed.
+	err error
+}
+
+func (c *fakeChat) HandleQuery(ctx context.Context, message models.Message, handler chat.Handler) (chat.Answer, error) {
+	if message.Command != models.CommandRecall {
+		c.mu.Lock()
+		c.saved = append(c.saved, message)
+		c.mu.Unlock()
+		return chat.Answer{}, nil
+	}
+
+	c.mu.Lock()
+	c.asked = append(c.asked, message)
+	c.mu.Unlock()
+	for _, chunk := range c.answer {
+		if err := handler(ctx, []byte(chunk)); err != nil {
+			return chat.Answer{}, err
+		}
+	}
+	if c.err != nil {
+		return chat.Answer{}, c.err
+	}
+	return chat.Answer{
+		Text:    strings.Join(c.answer, ""),
+		Sources: []chat.Source{{Content: "'s1kai': dubai was amazing", Metadata: map[string]any{"topic": message.Topic}}},
+	}, nil
+}
+
+func (c *fakeChat) Recall(_ context.Context, message models.Message) (chat.Answer, error) {
+	c.mu.Lock()
+	c.recalled = append(c.recalled, message)
+	c.mu.Unlock()
+	return chat.Answer{Text: strings.Join(c.answer, "")}, nil
+}
+
+func (c *fakeChat) DescribeImage(context.Context, string, []byte) (string, error) {
+	return "", chat.ErrVisionDisabled
+}
+
+func (c *fakeChat) SaveFile(_ context.Context, _ models.Message, file chat.File) (int, error) {
+	data, err := io.ReadAll(io.NewSectionReader(file.Content, 0, file.Size))
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	c.files = append(c.files, string(data))
+	c.mu.Unlock()
+	return 1, nil
+}
+
+// EditMessage records the edit and fails it like the chat service
+// if the message was never saved.
+func (c *fakeChat) EditMessage(_ context.Context, message models.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.edited = append(c.edited, message)
+	for _, m := range c.saved {
+		if m.ChatID == message.ChatID && m.MessageID == message.MessageID {
+			return nil
+		}
+	}
+	return chat.ErrNotStored
+}
+
+func (c *fakeChat) Forget(_ context.Context, _ models.UserID, f chat.Forget) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.forgets = append(c.forgets, f)
+	return 0, nil
+}
+
+func (c *fakeChat) forgetFilters() []chat.Forget {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]chat.Forget(nil), c.forgets...)
+}
+
+func (c *fakeChat) editedMessages() []models.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]models.Message(nil), c.edited...)
+}
+
+func (c *fakeChat) savedMessages() []models.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]models.Message(nil), c.saved...)
+}
+
+func (c *fakeChat) recalledMessages() []models.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]models.Message(nil), c.recalled...)
+}
+
+func (c *fakeChat) savedFiles() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.files...)
+}
+
+func (c *fakeChat) askedMessages() []models.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]models.Message(nil), c.asked...)
+}
+
+// startBot wires the handler, dispatcher and deduplicator to the fake server
+// like cmd/service does. It returns the update entry point and a function
+// that waits until the received updates are processed.
+func startBot(t *testing.T, srv *telegramtest.Server, c *fakeChat) (*telegram.Deduplicator, func()) {
+	t.Helper()
+	return startBotWithLimit(t, srv, c, 0)
+}
+
+// startBotWithLimit is startBot with a cap on the size of documents.
+func startBotWithLimit(t *testing.T, srv *telegramtest.Server, c *fakeChat, maxDocumentSize int64) (*telegram.Deduplicator, func()) {
+	t.Helper()
+	b := srv.Bot()
+	me, err := b.GetMe(context.Background())
+	require.NoError(t, err)
+
+	h := telegram.NewHandler(c, b, nil, me, []int64{userID, groupID}, maxDocumentSize)
+	d := telegram.NewDispatcher(h, 2, 10)
+	d.Start()
+	drain := sync.OnceFunc(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		d.Close(ctx)
+	})
+	t.Cleanup(drain)
+
+	dd, err := telegram.NewDeduplicator(d, time.Hour, "")
+	require.NoError(t, err)
+	return dd, drain
+}
+
+func privateMessage(id int64, text string) telegram.Update {
+	return telegram.Update{Message: &telegram.Message{
+		MessageID: id,
+		From:      &telegram.User{ID: userID, FirstName: "Ivan", Username: "s1kai"},
+		Chat:      telegram.Chat{ID: userID, Type: telegram.ChatTypePrivate},
+		Date:      time.Now().Unix(),
+		Text:      text,
+	}}
+}
+
+func hasText(text string) func(telegramtest.Call) bool {
+	return func(c telegramtest.Call) bool {
+		return c.Params["text"] == text
+	}
+}
+
+func TestPollingAsk(t *testing.T) {
+	srv := telegramtest.NewServer(t)
+	c := &fakeChat{answer: []string{"You were ", "in Dubai."}}
+	updates, drain := startBot(t, srv, c)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = telegram.NewPoller(srv.Bot(), updates, nil, time.Second).Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		drain()
+	})
+
+	srv.PushUpdate(privateMessage(10, "/ask #travel where was I?"))
+
+	placeholder := srv.Wait("sendMessage", 1, nil)[0]
+	require.Equal(t, "thinking...", placeholder.Params["text"])
+
+	final := srv.Wait("editMessageText", 1, hasText("You were in Dubai."))
+	markup := final[len(final)-1].Markup()
+	require.NotNil(t, markup)
+	require.Len(t, markup.InlineKeyboard, 2, "answer buttons and sources")
+
+	asked := c.askedMessages()
+	require.Len(t, asked, 1)
+	require.Equal(t, "#travel", asked[0].Topic)
+	require.Equal(t, "where was I?", asked[0].Text)
+}
+
+func TestAskFailure(t *testing.T) {
+	srv := telegramtest.NewServer(t)
+	c := &fakeChat{answer: []string{"You were "}, err: errors.New("model is down")}
+	updates, drain := startBot(t, srv, c)
+	wh := telegram.NewWebhookHandler(secretToken, updates)
+
+	telegramtest.PostWebhook(t, wh, secretToken, privateMessage(12, "/ask where was I?"))
+	drain()
+
+	edits := srv.Calls("editMessageText")
+	require.NotEmpty(t, edits)
+	final := edits[len(edits)-1]
+	require.Equal(t, "You were \n\n⚠️ Something went wrong, please try again.", final.Params["text"])
+	require.Nil(t, final.Markup(), "a failed answer has no buttons")
+}
+
+func TestWebhookSave(t *testing.T) {
+	srv := telegramtest.NewServer(t)
+	c := &fakeChat{}
+	updates, _ := startBot(t, srv, c)
+	wh := telegram.NewWebhookHandler(secretToken, updates)
+
+	update := privateMessage(11, "#travel dubai was amazing")
+	update.UpdateID = 7
+	resp := telegramtest.PostWebhook(t, wh, secretToken, update)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	srv.Wait("editMessageText", 1, hasText("saved"))
+
+	// A redelivered update is not processed twice.
+	resp = telegramtest.PostWebhook(t, wh, secretToken, update)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	saved := c.savedMessages()
+	require.Len(t, saved, 1)
+	require.Equal(t, "dubai was amazing", saved[0].Text)
+	require.Equal(t, models.NewUserID(userID, userID), saved[0].UserName)
+
+	resp = telegramtest.PostWebhook(t, wh, "wrong", update)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestWebhookEdit(t *testing.T) {
+	srv := telegramtest.NewServer(t)
+	c := &fakeChat{}
+	updates, drain := startBot(t, srv, c)
+	wh := telegram.NewWebhookHandler(secretToken, updates)
+
+	sent := time.Now().Add(-time.Hour).Truncate(time.Second)
+	edit := func(updateID int64, message *telegram.Message) telegram.Update {
+		edited := *message
+		edited.EditDate = time.Now().Unix()
+		return telegram.Update{UpdateID: updateID, EditedMessage: &edited}
+	}
+
+	update := privateMessage(20, "#travel dubai was amzing")
+	update.UpdateID = 50
+	update.Message.Date = sent.Unix()
+	telegramtest.PostWebhook(t, wh, secretToken, update)
+
+	fixed := edit(51, update.Message)
+	fixed.EditedMessage.Text = "#travel dubai was amazing"
+	telegramtest.PostWebhook(t, wh, secretToken, fixed)
+
+	// Neither commands nor questions about documents are stored.
+	command := privateMessage(21, "/ask where was I?")
+	command.EditedMessage, command.Message = command.Message, nil
+	command.UpdateID = 52
+	telegramtest.PostWebhook(t, wh, secretToken, command)
+
+	reply := privateMessage(22, "what is it about?")
+	reply.Message.ReplyToMessage = &telegram.Message{
+		MessageID: 19,
+		Chat:      reply.Message.Chat,
+		Document:  &telegram.Document{FileID: "doc", FileUniqueID: "doc", FileName: "doc.txt"},
+	}
+	telegramtest.PostWebhook(t, wh, secretToken, edit(53, reply.Message))
+	drain()
+
+	edited := c.editedMessages()
+	require.Len(t, edited, 1)
+	require.Equal(t, int64(20), edited[0].MessageID)
+	require.Equal(t, int64(userID), edited[0].ChatID)
+	require.True(t, sent.Equal(edited[0].TimeSend), "edits keep the time the message was sent")
+	require.Equal(t, "dubai was amazing", edited[0].Text)
+	require.Equal(t, "#travel", edited[0].Topic)
+}
+
+func TestWebhookDocument(t *testing.T) {
+	srv := telegramtest.NewServer(t)
+	c := &fakeChat{}
+	updates, drain := startBotWithLimit(t, srv, c, 10)
+	wh := telegram.NewWebhookHandler(secretToken, updates)
+
+	document := func(updateID int64, fileID string, data string) telegram.Update {
+		srv.AddFile(fileID, []byte(data))
+		update := privateMessage(updateID, "")
+		update.UpdateID = updateID
+		update.Message.Document = &telegram.Document{
+			FileID:       fileID,
+			FileUniqueID: fileID,
+			FileName:     fileID + ".txt",
+			FileSize:     int64(len(data)),
+		}
+		return update
+	}
+
+	telegramtest.PostWebhook(t, wh, secretToken, document(40, "small", "notes"))
+	srv.Wait("sendMessage", 1, hasText("saved small.txt, 1 chunks. Reply to the file to ask about it."))
+
+	telegramtest.PostWebhook(t, wh, secretToken, document(41, "large", "more than ten bytes"))
+	srv.Wait("sendMessage", 1, hasText("⚠️ Files over 0 MB are not supported."))
+
+	// A file larger than announced is cut off while downloading.
+	update := document(42, "liar", "more than ten bytes")
+	update.Message.Document.FileSize = 5
+	telegramtest.PostWebhook(t, wh, secretToken, update)
+	srv.Wait("sendMessage", 1, hasText("⚠️ Could not process the file."))
+	drain()
+
+	require.Equal(t, []string{"notes"}, c.savedFiles())
+}
+
+func TestForumTopicReply(t *testing.T) {
+	srv := telegramtest.NewServer(t)
+	c := &fakeChat{answer: []string{"Ship the release."}}
+	updates, _ := startBot(t, srv, c)
+	wh := telegram.NewWebhookHandler(secretToken, updates)
+
+	forum := telegram.Chat{ID: -1002233445566, Type: telegram.ChatTypeSupergroup, Title: "Team", IsForum: true}
+	telegramtest.PostWebhook(t, wh, secretToken, telegram.Update{UpdateID: 20, Message: &telegram.Message{
+		MessageID:         36,
+		MessageThreadID:   36,
+		From:              &telegram.User{ID: userID, FirstName: "Ivan"},
+		Chat:              forum,
+		ForumTopicCreated: &telegram.ForumTopicCreated{Name: "Release plan"},
+	}})
+	telegramtest.PostWebhook(t, wh, secretToken, telegram.Update{UpdateID: 21, Message: &telegram.Message{
+		MessageID:       40,
+		MessageThreadID: 36,
+		IsTopicMessage:  true,
+		From:            &telegram.User{ID: userID, FirstName: "Ivan"},
+		Chat:            forum,
+		Text:            "/ask what is next?",
+		Entities:        []telegram.MessageEntity{{Type: "bot_command", Offset: 0, Length: 4}},
+	}})
+
+	placeholder := srv.Wait("sendMessage", 1, nil)[0]
+	require.Equal(t, "36", placeholder.Params["message_thread_id"])
+	srv.Wait("editMessageText", 1, hasText("Ship the release."))
+
+	asked := c.askedMessages()
+	require.Len(t, asked, 1)
+	require.Equal(t, "#thread_36", asked[0].Topic)
+	require.Equal(t, "Release plan", asked[0].TopicName)
+}
+
+func TestForumTopicForget(t *testing.T) {
+	srv := telegramtest.NewServer(t)
+	c := &fakeChat{}
+	updates, drain := startBot(t, srv, c)
+	wh := telegram.NewWebhookHandler(secretToken, updates)
+
+	forum := telegram.Chat{ID: -1002233445566, Type: telegram.ChatTypeSupergroup, Title: "Team", IsForum: true}
+	root := &telegram.Message{
+		MessageID:         36,
+		MessageThreadID:   36,
+		Chat:              forum,
+		ForumTopicCreated: &telegram.ForumTopicCreated{Name: "Release plan"},
+	}
+	forget := func(updateID int64, text string, replyTo *telegram.Message) {
+		telegramtest.PostWebhook(t, wh, secretToken, telegram.Update{UpdateID: updateID, Message: &telegram.Message{
+			MessageID:       updateID,
+			MessageThreadID: 36,
+			IsTopicMessage:  true,
+			From:            &telegram.User{ID: userID, FirstName: "Ivan"},
+			Chat:            forum,
+			ReplyToMessage:  replyTo,
+			Text:            text,
+			Entities:        []telegram.MessageEntity{{Type: "bot_command", Offset: 0, Length: 7}},
+		}})
+	}
+
+	// Topic messages reply to the topic root without the user asking for it.
+	forget(70, "/forget #travel", root)
+	forget(71, "/forget", root)
+	forget(72, "/forget", &telegram.Message{MessageID: 38, MessageThreadID: 36, IsTopicMessage: true, Chat: forum, Text: "old plan"})
+	srv.Wait("sendMessage", 3, hasText("Nothing to forget."))
+	drain()
+
+	require.Equal(t, []chat.Forget{
+		{Topic: "#travel"},
+		{Topic: "#thread_36"},
+		{ChatID: forum.ID, MessageID: 38},
+	}, c.forgetFilters())
+}
+
+func TestAnswerButtons(t *testing.T) {
+	srv := telegramtest.NewServer(t)
+	c := &fakeChat{answer: []string{"You were in Dubai."}}
+	updates, _ := startBot(t, srv, c)
+	wh := telegram.NewWebhookHandler(secretToken, updates)
+
+	group := telegram.Chat{ID: groupID, Type: telegram.ChatTypeSupergroup, Title: "Friends"}
+	telegramtest.PostWebhook(t, wh, secretToken, telegram.Update{UpdateID: 30, Message: &telegram.Message{
+		MessageID: 50,
+		From:      &telegram.User{ID: userID, FirstName: "Ivan"},
+		Chat:      group,
+		Text:      "/ask where was I?",
+		Entities:  []telegram.MessageEntity{{Type: "bot_command", Offset: 0, Length: 4}},
+	}})
+	final := srv.Wait("editMessageText", 1, func(c telegramtest.Call) bool {
+		m := c.Markup()
+		return m != nil && len(m.InlineKeyboard) == 2
+	})
+	markup := final[0].Markup()
+	regenerate, sources := markup.InlineKeyboard[0][0].CallbackData, markup.InlineKeyboard[1][0].CallbackData
+	_, id, _ := strings.Cut(regenerate, ":")
+
+	updateID := int64(31)
+	press := func(from int64, data string) telegramtest.Call {
+		t.Helper()
+		n := len(srv.Calls("answerCallbackQuery"))
+		telegramtest.PostWebhook(t, wh, secretToken, telegram.Update{UpdateID: updateID, CallbackQuery: &telegram.CallbackQuery{
+			ID:      strconv.FormatInt(updateID, 10),
+			From:    telegram.User{ID: from, FirstName: "Anna"},
+			Message: &telegram.Message{MessageID: 1, Chat: group},
+			Data:    data,
+		}})
+		updateID++
+		return srv.Wait("answerCallbackQuery", n+1, nil)[n]
+	}
+
+	// Other members of the group can't act on the answer.
+	const otherID = 2
+	for _, data := range []string{regenerate, sources, "stop:" + id} {
+		require.Equal(t, "Not allowed.", press(otherID, data).Params["text"], data)
+	}
+	require.Len(t, srv.Calls("sendMessage"), 1, "only the placeholder")
+	require.Len(t, c.askedMessages(), 1)
+
+	press(userID, sources)
+	srv.Wait("sendMessage", 2, nil)
+
+	// The answer is over, its stop button stops nothing.
+	require.Empty(t, press(userID, "stop:"+id).Params["text"])
+
+	press(userID, regenerate)
+	srv.Wait("sendMessage", 3, nil)
+	require.Eventually(t, func() bool { return len(c.askedMessages()) == 2 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestInlineQuery(t *testing.T) {
+	srv := telegramtest.NewServer(t)
+	c := &fakeChat{answer: []string{"You were in Dubai."}}
+	updates, _ := startBot(t, srv, c)
+	wh := telegram.NewWebhookHandler(secretToken, updates)
+
+	// Every keystroke sends a query, only the last one is answered.
+	for i, query := range []string{"where", "where was I?"} {
+		telegramtest.PostWebhook(t, wh, secretToken, telegram.Update{UpdateID: int64(60 + i), InlineQuery: &telegram.InlineQuery{
+			ID:    strconv.Itoa(60 + i),
+			From:  telegram.User{ID: userID, FirstName: "Ivan"},
+			Query: query,
+		}})
+	}
+
+	answer := srv.Wait("answerInlineQuery", 1, nil)[0]
+	require.Equal(t, "61", answer.Params["inline_query_id"])
+	var results []telegram.InlineQueryResultArticle
+	require.NoError(t, json.Unmarshal([]byte(answer.Params["results"]), &results))
+	require.Len(t, results, 1)
+	require.Equal(t, "where was I?", results[0].Title)
+	require.Equal(t, "You were in Dubai.", results[0].InputMessageContent.MessageText)
+
+	recalled := c.recalledMessages()
+	require.Len(t, recalled, 1)
+	require.Equal(t, models.NewUserID(userID, userID), recalled[0].UserName)
+	require.Empty(t, c.askedMessages(), "inline queries are not part of the conversation")
+}
+
+func TestFollowUp(t *testing.T) {
+	srv := telegramtest.NewServer(t)
+	c := &fakeChat{answer: []string{"You were in Dubai."}}
+	updates, drain := startBot(t, srv, c)
+	wh := telegram.NewWebhookHandler(secretToken, updates)
+	bot := &srv.Me
+
+	replyTo := func(updateID int64, text string, messageID string) {
+		id, err := strconv.ParseInt(messageID, 10, 64)
+		require.NoError(t, err)
+		update := privateMessage(updateID, text)
+		update.UpdateID = updateID
+		update.Message.ReplyToMessage = &telegram.Message{MessageID: id, From: bot, Chat: update.Message.Chat}
+		telegramtest.PostWebhook(t, wh, secretToken, update)
+	}
+
+	update := privateMessage(80, "/ask #travel where was I?")
+	update.UpdateID = 80
+	telegramtest.PostWebhook(t, wh, secretToken, update)
+	answer := srv.Wait("editMessageText", 1, hasText("You were in Dubai."))[0]
+
+	update = privateMessage(81, "#notes buy milk")
+	update.UpdateID = 81
+	telegramtest.PostWebhook(t, wh, secretToken, update)
+	saved := srv.Wait("editMessageText", 1, hasText("saved"))[0]
+
+	// A reply to an answer asks about the same topic.
+	replyTo(82, "tell me more about this", answer.Params["message_id"])
+	srv.Wait("editMessageText", 2, hasText("You were in Dubai."))
+
+	// A reply to other messages of the bot is not a question.
+	replyTo(83, "thanks", saved.Params["message_id"])
+	srv.Wait("editMessageText", 2, hasText("saved"))
+	drain()
+
+	asked := c.askedMessages()
+	require.Len(t, asked, 2)
+	require.Equal(t, "#travel", asked[1].Topic)
+	require.Equal(t, "tell me more about this", asked[1].Text)
+
+	notes := c.savedMessages()
+	require.Len(t, notes, 2)
+	require.Equal(t, "thanks", notes[1].Text)
+	require.Equal(t, "#default", notes[1].Topic)
+}
+
+func TestWebhookNotAllowed(t *testing.T) {
+	srv := telegramtest.NewServer(t)
+	c := &fakeChat{}
+	updates, drain := startBot(t, srv, c)
+	wh := telegram.NewWebhookHandler(secretToken, updates)
+
+	update := privateMessage(12, "hi")
+	update.UpdateID = 8
+	update.Message.From.ID, update.Message.Chat.ID = 1, 1
+	resp := telegramtest.PostWebhook(t, wh, secretToken, update)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	drain()
+
+	require.Empty(t, c.savedMessages())
+	require.Empty(t, srv.Calls("sendMessage"))
+}
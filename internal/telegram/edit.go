@@ -0,0 +1,41 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"tgpt/internal/chat"
+)
+
+// handleEditedMessage replaces the remembered text of an edited message.
+// Only text messages are synced: commands and questions are not
+// remembered, and captions are stored together with the media text.
+func (h *Handler) handleEditedMessage(ctx context.Context, message *Message) error {
+	if !h.isAllowed(message) {
+		return nil
+	}
+	h.forumTopics.resolve(message)
+	if message.Text == "" {
+		slog.Debug("skip edited message without text", "message_id", message.MessageID)
+		return nil
+	}
+	if _, _, ok := h.commands.parse(message.Text, h.me.Username); ok || message.repliedDocument() != nil {
+		return nil
+	}
+
+	businessMessage := message.toBuisnessModel()
+	businessMessage.Text = h.stripMention(businessMessage.Text)
+
+	err := h.chatService.EditMessage(ctx, businessMessage)
+	if errors.Is(err, chat.ErrNotStored) {
+		slog.Debug("skip edit of a message that is not stored",
+			"chat_id", message.Chat.ID, "message_id", message.MessageID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("edit message: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"tgpt/internal/chat"
+	"tgpt/internal/models"
+)
+
+const (
+	actionForgetAll    = "forget"
+	actionForgetCancel = "keep"
+)
+
+const (
+	textForgetUsage = "Reply /forget to a message to delete it, or use /forget #topic, " +
+		"/forget since:2024-05-01 until:2024-05-31 or /forget all."
+	textForgetConfirm   = "Forget everything you have told me in this chat?"
+	textForgetCancelled = "Nothing was forgotten."
+	textForgot          = "Forgot %d memories."
+	textNothingToForget = "Nothing to forget."
+)
+
+var errForgetArgs = errors.New("bad forget arguments")
+
+// parseForget parses /forget arguments: a #topic, since:DATE and
+// until:DATE (both inclusive, YYYY-MM-DD) or a single "all".
+func parseForget(args string, loc *time.Location) (f chat.Forget, all bool, err error) {
+	for _, arg := range strings.Fields(args) {
+		key, value, _ := strings.Cut(arg, ":")
+		switch {
+		case strings.EqualFold(arg, "all"):
+			all = true
+		case strings.HasPrefix(arg, "#") && len(arg) > 1:
+			f.Topic = arg
+		case key == "since" || key == "until":
+			day, err := time.ParseInLocation(time.DateOnly, value, loc)
+			if err != nil {
+				return chat.Forget{}, false, fmt.Errorf("%w: %s", errForgetArgs, arg)
+			}
+			if key == "since" {
+				f.Since = day
+			} else {
+				f.Until = day.AddDate(0, 0, 1)
+			}
+		default:
+			return chat.Forget{}, false, fmt.Errorf("%w: %s", errForgetArgs, arg)
+		}
+	}
+	if all && f != (chat.Forget{}) {
+		return chat.Forget{}, false, fmt.Errorf("%w: all can't be combined with filters", errForgetArgs)
+	}
+	return f, all, nil
+}
+
+func (h *Handler) commandForget(ctx context.Context, message *Message, args string) error {
+	f, all, err := parseForget(args, time.Local)
+	if err != nil {
+		return h.reply(ctx, message, textForgetUsage)
+	}
+	if r := message.repliedMessage(); r != nil && !all {
+		f.ChatID, f.MessageID = r.Chat.ID, r.MessageID
+	}
+	if f == (chat.Forget{}) && !all {
+		// A bare /forget in a forum topic forgets the topic.
+		f.Topic = message.forumTopic()
+	}
+
+	switch {
+	case all:
+		sender := strconv.FormatInt(message.senderID(), 10)
+		_, err = h.bot.SendMessage(ctx, message.Chat.ID, textForgetConfirm,
+			WithReplyTo(message.MessageID),
+			WithReplyMarkup(&InlineKeyboardMarkup{InlineKeyboard: [][]InlineKeyboardButton{{
+				{Text: "🗑 Forget everything", CallbackData: callbackData(actionForgetAll, sender)},
+				{Text: "Cancel", CallbackData: callbackData(actionForgetCancel, sender)},
+			}}}),
+		)
+		if err != nil {
+			return fmt.Errorf("send confirmation: %w", err)
+		}
+		return nil
+	case f == chat.Forget{}:
+		return h.reply(ctx, message, textForgetUsage)
+	default:
+		text, err := h.forget(ctx, models.NewUserID(message.Chat.ID, message.senderID()), f)
+		if err != nil {
+			_ = h.reply(ctx, message, textFailed)
+			return err
+		}
+		return h.reply(ctx, message, text)
+	}
+}
+
+// handleForgetCallback handles the buttons of the /forget all confirmation.
+// Only the user who asked can press them.
+func (h *Handler) handleForgetCallback(ctx context.Context, query *CallbackQuery, action, sender string) error {
+	if sender != strconv.FormatInt(query.From.ID, 10) {
+		return h.ackCallback(ctx, query, textNotAllowed)
+	}
+	err := h.ackCallback(ctx, query, "")
+	if err != nil {
+		return err
+	}
+
+	text := textForgetCancelled
+	if action == actionForgetAll {
+		text, err = h.forget(ctx, models.NewUserID(query.Message.Chat.ID, query.From.ID), chat.Forget{})
+		if err != nil {
+			text = textFailed
+		}
+	}
+
+	// Editing without markup removes the buttons.
+	_, editErr := h.bot.UpdateMessage(ctx, query.Message.Chat.ID, query.Message.MessageID, text)
+	if editErr != nil && err == nil {
+		err = fmt.Errorf("update confirmation: %w", editErr)
+	}
+	return err
+}
+
+func (h *Handler) forget(ctx context.Context, user models.UserID, f chat.Forget) (string, error) {
+	n, err := h.chatService.Forget(ctx, user, f)
+	if err != nil {
+		return "", fmt.Errorf("forget: %w", err)
+	}
+	if n == 0 {
+		return textNothingToForget, nil
+	}
+	return fmt.Sprintf(textForgot, n), nil
+}
@@ -0,0 +1,48 @@
+package telegram
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"tgpt/internal/chat"
+)
+
+func TestParseForget(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.ParseInLocation(time.DateOnly, s, time.UTC)
+		require.NoError(t, err)
+		return d
+	}
+
+	for _, tc := range []struct {
+		args    string
+		want    chat.Forget
+		all     bool
+		wantErr bool
+	}{
+		{args: ""},
+		{args: "all", all: true},
+		{args: "#travel", want: chat.Forget{Topic: "#travel"}},
+		{
+			args: "#travel since:2024-05-01 until:2024-05-31",
+			want: chat.Forget{Topic: "#travel", Since: day("2024-05-01"), Until: day("2024-06-01")},
+		},
+		{args: "until:2024-05-31", want: chat.Forget{Until: day("2024-06-01")}},
+		{args: "since:yesterday", wantErr: true},
+		{args: "everything", wantErr: true},
+		{args: "all #travel", wantErr: true},
+	} {
+		t.Run(tc.args, func(t *testing.T) {
+			f, all, err := parseForget(tc.args, time.UTC)
+			if tc.wantErr {
+				require.ErrorIs(t, err, errForgetArgs)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, f)
+			require.Equal(t, tc.all, all)
+		})
+	}
+}
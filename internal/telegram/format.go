@@ -0,0 +1,163 @@
+package telegram
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// inlineMarkers maps Markdown emphasis markers to HTML tags,
+// longer markers go first so "**" is not read as "*".
+var inlineMarkers = []struct {
+	marker string
+	tag    string
+}{
+	{"**", "b"},
+	{"__", "b"},
+	{"~~", "s"},
+	{"*", "i"},
+	{"_", "i"},
+}
+
+// renderHTML converts Markdown produced by the model into the HTML
+// subset supported by Telegram. Text is escaped, so the result is
+// always valid. Markup that is not closed yet, as it happens while
+// an answer is streamed, is rendered as plain text, except code
+// blocks which are closed at the end of the text.
+func renderHTML(markdown string) string {
+	var (
+		out       []string
+		inCode    bool
+		lang      string
+		codeLines []string
+	)
+	flushCode := func() {
+		open := "<pre><code>"
+		if lang != "" {
+			open = `<pre><code class="language-` + html.EscapeString(lang) + `">`
+		}
+		out = append(out, open+html.EscapeString(strings.Join(codeLines, "\n"))+"</code></pre>")
+	}
+
+	for _, line := range strings.Split(markdown, "\n") {
+		switch {
+		case strings.HasPrefix(line, codeFence) && inCode:
+			flushCode()
+			inCode = false
+		case strings.HasPrefix(line, codeFence):
+			inCode = true
+			lang = strings.TrimSpace(strings.TrimPrefix(line, codeFence))
+			codeLines = nil
+		case inCode:
+			codeLines = append(codeLines, line)
+		default:
+			out = append(out, renderLine(line))
+		}
+	}
+	if inCode {
+		flushCode()
+	}
+
+	return strings.Join(out, "\n")
+}
+
+func renderLine(line string) string {
+	trimmed := strings.TrimLeft(line, " ")
+	indent := line[:len(line)-len(trimmed)]
+
+	switch {
+	case strings.HasPrefix(trimmed, "#"):
+		title := strings.TrimLeft(trimmed, "#")
+		if strings.HasPrefix(title, " ") {
+			return indent + "<b>" + renderInline(strings.TrimSpace(title)) + "</b>"
+		}
+	case strings.HasPrefix(trimmed, "- "), strings.HasPrefix(trimmed, "* "):
+		return indent + "• " + renderInline(trimmed[2:])
+	}
+	return indent + renderInline(trimmed)
+}
+
+func renderInline(s string) string {
+	var sb strings.Builder
+
+	for i := 0; i < len(s); {
+		rest := s[i:]
+
+		if rest[0] == '`' {
+			if end := strings.IndexByte(rest[1:], '`'); end > 0 {
+				sb.WriteString("<code>" + html.EscapeString(rest[1:end+1]) + "</code>")
+				i += end + 2
+				continue
+			}
+		}
+
+		if rest[0] == '[' {
+			if text, url, n, ok := parseLink(rest); ok {
+				sb.WriteString(`<a href="` + html.EscapeString(url) + `">` + renderInline(text) + "</a>")
+				i += n
+				continue
+			}
+		}
+
+		if inner, tag, n, ok := parseEmphasis(s, i); ok {
+			sb.WriteString("<" + tag + ">" + renderInline(inner) + "</" + tag + ">")
+			i += n
+			continue
+		}
+
+		sb.WriteString(html.EscapeString(rest[:1]))
+		i++
+	}
+	return sb.String()
+}
+
+// parseLink parses [text](url) at the start of s.
+func parseLink(s string) (text, url string, n int, ok bool) {
+	closeText := strings.Index(s, "](")
+	if closeText < 0 {
+		return "", "", 0, false
+	}
+	closeURL := strings.IndexByte(s[closeText+2:], ')')
+	if closeURL < 0 {
+		return "", "", 0, false
+	}
+	text = s[1:closeText]
+	url = s[closeText+2 : closeText+2+closeURL]
+	if text == "" || url == "" || strings.ContainsAny(url, " \n") {
+		return "", "", 0, false
+	}
+	return text, url, closeText + 2 + closeURL + 1, true
+}
+
+// parseEmphasis parses an emphasis span starting at s[i].
+// Underscores must stand at word boundaries, so snake_case stays as is.
+func parseEmphasis(s string, i int) (inner, tag string, n int, ok bool) {
+	for _, m := range inlineMarkers {
+		if !strings.HasPrefix(s[i:], m.marker) {
+			continue
+		}
+		if m.marker[0] == '_' && i > 0 && isWordByte(s[i-1]) {
+			return "", "", 0, false
+		}
+
+		start := i + len(m.marker)
+		end := strings.Index(s[start:], m.marker)
+		if end <= 0 {
+			continue
+		}
+		end += start
+		if s[start] == ' ' || s[end-1] == ' ' {
+			continue
+		}
+		after := end + len(m.marker)
+		if m.marker[0] == '_' && after < len(s) && isWordByte(s[after]) {
+			continue
+		}
+		return s[start:end], m.tag, after - i, true
+	}
+	return "", "", 0, false
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || b >= 0x80 || unicode.IsLetter(rune(b)) || unicode.IsDigit(rune(b))
+}
@@ -0,0 +1,46 @@
+package telegram
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderHTML(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		markdown string
+		want     string
+	}{
+		{"escapes", "a < b && c > d", "a &lt; b &amp;&amp; c &gt; d"},
+		{"bold and italic", "**bold** and *italic* and __also__", "<b>bold</b> and <i>italic</i> and <b>also</b>"},
+		{"nested", "**bold _italic_**", "<b>bold <i>italic</i></b>"},
+		{"snake case", "use snake_case_names here", "use snake_case_names here"},
+		{"inline code", "run `a<b` now", "run <code>a&lt;b</code> now"},
+		{"link", "see [docs](https://example.com/?a=1&b=2)", `see <a href="https://example.com/?a=1&amp;b=2">docs</a>`},
+		{"heading", "## Title", "<b>Title</b>"},
+		{"list", "- one\n* two", "• one\n• two"},
+		{"code block", "```go\nif a < b {\n}\n```\nafter", "<pre><code class=\"language-go\">if a &lt; b {\n}</code></pre>\nafter"},
+		{"unclosed code block", "text\n```\nx := 1", "text\n<pre><code>x := 1</code></pre>"},
+		{"unclosed bold", "this is **bol", "this is **bol"},
+		{"unclosed inline code", "call `fmt.Pri", "call `fmt.Pri"},
+		{"unclosed link", "see [docs](https://exa", "see [docs](https://exa"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, renderHTML(tc.markdown))
+		})
+	}
+}
+
+func TestMessageWriterFallsBackToPlainText(t *testing.T) {
+	ctx := context.Background()
+	bot := newFakeEditor()
+	bot.errs = []error{&APIError{Code: 400, Description: "Bad Request: can't parse entities: unsupported start tag"}}
+	w := newTestWriter(bot, &fakeClock{now: time.Now()})
+
+	require.NoError(t, w.Write(ctx, []byte("**a < b**")))
+	require.NoError(t, w.Close(ctx))
+	require.Equal(t, "**a < b**", bot.texts[1])
+}
@@ -0,0 +1,91 @@
+package telegram
+
+import (
+	"strconv"
+	"sync"
+)
+
+// ForumTopicCreated is the service message about a new forum topic.
+type ForumTopicCreated struct {
+	Name              string `json:"name"`
+	IconColor         int    `json:"icon_color"`
+	IconCustomEmojiID string `json:"icon_custom_emoji_id,omitempty"`
+}
+
+// ForumTopicEdited is the service message about a renamed forum topic.
+type ForumTopicEdited struct {
+	Name              string `json:"name,omitempty"`
+	IconCustomEmojiID string `json:"icon_custom_emoji_id,omitempty"`
+}
+
+// threadID is the forum topic of the message, zero outside of topics.
+func (m Message) threadID() int64 {
+	if !m.IsTopicMessage {
+		return 0
+	}
+	return m.MessageThreadID
+}
+
+// repliedMessage is the message m is an explicit reply to. Telegram sets
+// reply_to_message of every topic message to the message that created
+// the topic, which is not a reply of the user.
+func (m Message) repliedMessage() *Message {
+	r := m.ReplyToMessage
+	if r == nil || r.ForumTopicCreated != nil || (m.IsTopicMessage && r.MessageID == m.MessageThreadID) {
+		return nil
+	}
+	return r
+}
+
+// forumTopic is the memory topic of the forum topic the message was sent
+// to, empty outside of topics. It is made of the thread id rather than
+// the topic name: names change and are not always known, while memories
+// of a thread must stay in one topic.
+func (m Message) forumTopic() string {
+	if m.threadID() == 0 {
+		return ""
+	}
+	return "#thread_" + strconv.FormatInt(m.MessageThreadID, 10)
+}
+
+type forumTopicKey struct {
+	chatID   int64
+	threadID int64
+}
+
+// forumTopics remembers names of forum topics to show them to users.
+// Telegram only sends the name when a topic is created or renamed,
+// and along with messages in the topic that don't reply to anything else.
+type forumTopics struct {
+	mu    sync.Mutex
+	names map[forumTopicKey]string
+}
+
+func newForumTopics() *forumTopics {
+	return &forumTopics{names: map[forumTopicKey]string{}}
+}
+
+// resolve learns the topic name from the message and fills it in.
+func (f *forumTopics) resolve(m *Message) {
+	if m.threadID() == 0 && m.ForumTopicCreated == nil && m.ForumTopicEdited == nil {
+		return
+	}
+	key := forumTopicKey{chatID: m.Chat.ID, threadID: m.MessageThreadID}
+
+	var name string
+	switch {
+	case m.ForumTopicCreated != nil:
+		name = m.ForumTopicCreated.Name
+	case m.ForumTopicEdited != nil:
+		name = m.ForumTopicEdited.Name
+	case m.ReplyToMessage != nil && m.ReplyToMessage.ForumTopicCreated != nil:
+		name = m.ReplyToMessage.ForumTopicCreated.Name
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if name != "" {
+		f.names[key] = name
+	}
+	m.forumTopicName = f.names[key]
+}
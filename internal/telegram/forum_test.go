@@ -0,0 +1,74 @@
+package telegram
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestForumTopic(t *testing.T) {
+	forum := Chat{ID: -100, Type: ChatTypeSupergroup, IsForum: true}
+	topics := newForumTopics()
+
+	created := &Message{
+		MessageID:         36,
+		MessageThreadID:   36,
+		Chat:              forum,
+		ForumTopicCreated: &ForumTopicCreated{Name: "Whiteboards"},
+	}
+	topics.resolve(created)
+
+	inTopic := func(text string) *Message {
+		return &Message{
+			MessageID:       40,
+			MessageThreadID: 36,
+			IsTopicMessage:  true,
+			Chat:            forum,
+			Text:            text,
+			ReplyToMessage:  &Message{MessageID: 38, Chat: forum, Text: "earlier message"},
+		}
+	}
+
+	m := inTopic("sprint goals")
+	topics.resolve(m)
+	business := m.toBuisnessModel()
+	require.Equal(t, "#thread_36", business.Topic)
+	require.Equal(t, "Whiteboards", business.TopicName)
+	require.Equal(t, int64(36), business.ThreadID)
+
+	m = inTopic("#retro went well")
+	topics.resolve(m)
+	require.Equal(t, "#retro", m.toBuisnessModel().Topic, "hashtags override the forum topic")
+
+	topics.resolve(&Message{MessageThreadID: 36, Chat: forum, ForumTopicEdited: &ForumTopicEdited{Name: "Boards"}})
+	m = inTopic("renamed")
+	topics.resolve(m)
+	business = m.toBuisnessModel()
+	require.Equal(t, "#thread_36", business.Topic, "renaming keeps memories in one topic")
+	require.Equal(t, "Boards", business.TopicName)
+
+	// The name comes along with messages that don't reply to anything else.
+	m = &Message{
+		MessageThreadID: 50,
+		IsTopicMessage:  true,
+		Chat:            forum,
+		Text:            "hi",
+		ReplyToMessage:  &Message{MessageID: 50, Chat: forum, ForumTopicCreated: &ForumTopicCreated{Name: "Ops"}},
+	}
+	topics.resolve(m)
+	require.Equal(t, "Ops", m.toBuisnessModel().TopicName)
+
+	// After a restart names are unknown, the topic is the same.
+	m = inTopic("after restart")
+	newForumTopics().resolve(m)
+	business = m.toBuisnessModel()
+	require.Equal(t, "#thread_36", business.Topic)
+	require.Empty(t, business.TopicName)
+
+	// Reply threads outside of forums are not topics.
+	m = &Message{MessageThreadID: 70, Chat: Chat{ID: -200, Type: ChatTypeSupergroup}, Text: "reply"}
+	topics.resolve(m)
+	business = m.toBuisnessModel()
+	require.Equal(t, "#default", business.Topic)
+	require.Zero(t, business.ThreadID)
+}
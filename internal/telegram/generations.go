@@ -0,0 +1,84 @@
+package telegram
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	textStopped       = "⏹ _stopped_"
+	textNothingToStop = "Nothing to stop."
+)
+
+// generation is an answer that is being generated.
+type generation struct {
+	cancel  context.CancelFunc
+	stopped atomic.Bool
+}
+
+// generations tracks the running generation of every chat, so it
+// can be stopped. Updates of a chat are processed in order, so
+// there is at most one per chat.
+type generations struct {
+	mu      sync.Mutex
+	running map[int64]*generation
+}
+
+func newGenerations() *generations {
+	return &generations{running: map[int64]*generation{}}
+}
+
+// start registers a generation in the chat. The returned context is
+// cancelled when it is stopped, done must be called when it is over.
+func (g *generations) start(ctx context.Context, chatID int64) (context.Context, *generation, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	gen := &generation{cancel: cancel}
+
+	g.mu.Lock()
+	g.running[chatID] = gen
+	g.mu.Unlock()
+
+	return ctx, gen, func() {
+		cancel()
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if g.running[chatID] == gen {
+			delete(g.running, chatID)
+		}
+	}
+}
+
+// stop cancels the generation running in the chat, it
+// reports false if there is none.
+func (g *generations) stop(chatID int64) bool {
+	return g.stopGeneration(chatID, nil)
+}
+
+// stopGeneration cancels gen if it is still running in the chat,
+// any running generation if gen is nil.
+func (g *generations) stopGeneration(chatID int64, gen *generation) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	running, ok := g.running[chatID]
+	if !ok || (gen != nil && running != gen) {
+		return false
+	}
+	running.stopped.Store(true)
+	running.cancel()
+	return true
+}
+
+// isStopCommand reports whether text is the /stop command. It is
+// checked before the update is queued, so it does not consult
+// the command registry.
+func isStopCommand(text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return false
+	}
+	command, _, _ := strings.Cut(fields[0], "@")
+	return command == "/stop" || command == "!stop"
+}
@@ -0,0 +1,26 @@
+package telegram
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerationsStop(t *testing.T) {
+	g := newGenerations()
+	_, first, done := g.start(context.Background(), 1)
+	done()
+
+	ctx, second, done := g.start(context.Background(), 1)
+	defer done()
+
+	require.False(t, g.stopGeneration(1, first), "a finished generation stops nothing")
+	require.NoError(t, ctx.Err())
+	require.False(t, g.stopGeneration(2, second))
+
+	require.True(t, g.stopGeneration(1, second))
+	require.Error(t, ctx.Err())
+	require.True(t, second.stopped.Load())
+	require.False(t, first.stopped.Load())
+}
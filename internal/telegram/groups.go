@@ -0,0 +1,86 @@
+package telegram
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf16"
+)
+
+// isAllowed reports whether the message comes from a white listed
+// user or was sent in a white listed chat.
+func (h *Handler) isAllowed(m *Message) bool {
+	return slices.Contains(h.whiteList, m.Chat.ID) ||
+		slices.Contains(h.whiteList, m.senderID())
+}
+
+// isAddressed reports whether the message is meant for the bot. In private
+// chats every message is, in groups only mentions, replies to the bot
+// and commands, including !aliases, are.
+func (h *Handler) isAddressed(m *Message) bool {
+	if m.Chat.Type == ChatTypePrivate {
+		return true
+	}
+	if h.repliesToBot(m) {
+		return true
+	}
+
+	text, entities := m.Text, m.Entities
+	if text == "" {
+		text, entities = m.Caption, m.CaptionEntities
+	}
+	for _, e := range entities {
+		switch e.Type {
+		case "mention":
+			if strings.EqualFold(entityText(text, e), "@"+h.me.Username) {
+				return true
+			}
+		case "text_mention":
+			if e.User != nil && e.User.ID == h.me.ID {
+				return true
+			}
+		case "bot_command":
+			if e.Offset != 0 {
+				continue
+			}
+			_, bot, found := strings.Cut(entityText(text, e), "@")
+			if !found || strings.EqualFold(bot, h.me.Username) {
+				return true
+			}
+		}
+	}
+
+	// Aliases like !bro have no entity. Other text starting with !
+	// is not meant for the bot, unless it names a command.
+	if strings.HasPrefix(strings.TrimSpace(text), "!") {
+		cmd, _, _ := h.commands.parse(text, h.me.Username)
+		return cmd != nil
+	}
+	return false
+}
+
+// repliesToBot reports whether the message replies to a message of the bot.
+func (h *Handler) repliesToBot(m *Message) bool {
+	r := m.repliedMessage()
+	return r != nil && r.From != nil && r.From.ID == h.me.ID
+}
+
+// stripMention removes mentions of the bot, so they do not end up in
+// memories and questions.
+func (h *Handler) stripMention(text string) string {
+	if h.me.Username == "" {
+		return text
+	}
+	re := regexp.MustCompile(`(?i)\s*@` + regexp.QuoteMeta(h.me.Username) + `\b`)
+	return strings.TrimSpace(re.ReplaceAllString(text, ""))
+}
+
+// entityText returns the part of text covered by e.
+// Entity offsets are counted in UTF-16 code units.
+func entityText(text string, e MessageEntity) string {
+	encoded := utf16.Encode([]rune(text))
+	if e.Offset < 0 || e.Offset+e.Length > len(encoded) {
+		return ""
+	}
+	return string(utf16.Decode(encoded[e.Offset : e.Offset+e.Length]))
+}
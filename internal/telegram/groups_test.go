@@ -0,0 +1,58 @@
+package telegram
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsAddressed(t *testing.T) {
+	h := &Handler{me: User{ID: 42, IsBot: true, Username: "tgpt_bot"}}
+	h.commands = h.newCommands()
+	group := Chat{ID: -100, Type: ChatTypeSupergroup}
+
+	for _, tc := range []struct {
+		name    string
+		message Message
+		want    bool
+	}{
+		{"private", Message{Chat: Chat{ID: 1, Type: ChatTypePrivate}, Text: "hi"}, true},
+		{"plain group message", Message{Chat: group, Text: "hi all"}, false},
+		{"mention", Message{Chat: group, Text: "hey @TGPT_bot what's up", Entities: []MessageEntity{
+			{Type: "mention", Offset: 4, Length: 9},
+		}}, true},
+		{"other mention", Message{Chat: group, Text: "hey @someone", Entities: []MessageEntity{
+			{Type: "mention", Offset: 4, Length: 8},
+		}}, false},
+		{"mention after emoji", Message{Chat: group, Text: "😀 @tgpt_bot", Entities: []MessageEntity{
+			{Type: "mention", Offset: 3, Length: 9},
+		}}, true},
+		{"reply to bot", Message{Chat: group, Text: "why?", ReplyToMessage: &Message{From: &User{ID: 42}}}, true},
+		{"command", Message{Chat: group, Text: "/help", Entities: []MessageEntity{
+			{Type: "bot_command", Offset: 0, Length: 5},
+		}}, true},
+		{"command for other bot", Message{Chat: group, Text: "/help@other_bot", Entities: []MessageEntity{
+			{Type: "bot_command", Offset: 0, Length: 15},
+		}}, false},
+		{"command for this bot", Message{Chat: group, Text: "/help@tgpt_bot", Entities: []MessageEntity{
+			{Type: "bot_command", Offset: 0, Length: 14},
+		}}, true},
+		{"alias", Message{Chat: group, Text: "!bro where was I?"}, true},
+		{"alias for other bot", Message{Chat: group, Text: "!bro@other_bot where was I?"}, false},
+		{"exclamation marks", Message{Chat: group, Text: "!!! deadline moved"}, false},
+		{"unknown alias", Message{Chat: group, Text: "!important read this"}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, h.isAddressed(&tc.message))
+		})
+	}
+}
+
+func TestIsAllowed(t *testing.T) {
+	h := &Handler{whiteList: []int64{7, -100}}
+
+	require.True(t, h.isAllowed(&Message{Chat: Chat{ID: 7}, From: &User{ID: 7}}))
+	require.True(t, h.isAllowed(&Message{Chat: Chat{ID: -100}, From: &User{ID: 8}}))
+	require.True(t, h.isAllowed(&Message{Chat: Chat{ID: -200}, From: &User{ID: 7}}))
+	require.False(t, h.isAllowed(&Message{Chat: Chat{ID: -200}, From: &User{ID: 8}}))
+}
@@ -2,13 +2,8 @@ package telegram
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
 	"log/slog"
-	"net/http"
-	"slices"
-	"strings"
 
 	"tgpt/internal/chat"
 	"tgpt/internal/models"
@@ -19,103 +14,244 @@ type chatService interface {
 		ctx context.Context,
 		message models.Message,
 		handler chat.Handler,
-	) error
+	) (chat.Answer, error)
+	Recall(ctx context.Context, message models.Message) (chat.Answer, error)
+	DescribeImage(ctx context.Context, mimeType string, image []byte) (string, error)
+	SaveFile(ctx context.Context, message models.Message, file chat.File) (int, error)
+	EditMessage(ctx context.Context, message models.Message) error
+	Forget(ctx context.Context, user models.UserID, f chat.Forget) (int, error)
 }
 
 func NewHandler(
 	chatService chatService,
 	bot *Bot,
-	secretToken string,
-	userWhiteList []string,
+	transcriber transcriber,
+	me User,
+	whiteList []int64,
+	maxDocumentSize int64,
 ) *Handler {
-	return &Handler{
-		chatService:   chatService,
-		bot:           bot,
-		secretToken:   secretToken,
-		userWhiteList: userWhiteList,
+	if maxDocumentSize == 0 {
+		maxDocumentSize = defaultMaxDocumentSize
 	}
+	h := &Handler{
+		chatService:     chatService,
+		bot:             bot,
+		transcriber:     transcriber,
+		me:              me,
+		whiteList:       whiteList,
+		maxDocumentSize: maxDocumentSize,
+		inline:          newInlineQueries(),
+		forumTopics:     newForumTopics(),
+		answers:         newAnswerRegistry(),
+		generations:     newGenerations(),
+	}
+	h.commands = h.newCommands()
+	return h
 }
 
 type Handler struct {
-	chatService   chatService
-	bot           *Bot
-	secretToken   string
-	userWhiteList []string
+	chatService chatService
+	bot         *Bot
+	// transcriber is nil if voice messages are not supported.
+	transcriber transcriber
+	// me is the bot's own user.
+	me User
+	// whiteList holds ids of users and chats the bot works for.
+	whiteList []int64
+	// maxDocumentSize is the size of the largest document
+	// the bot processes, in bytes.
+	maxDocumentSize int64
+	inline          *inlineQueries
+	answers         *answerRegistry
+
+	generations *generations
+	commands    *commandRegistry
+	forumTopics *forumTopics
 }
 
-func (h *Handler) HandleMessage(w http.ResponseWriter, r *http.Request) {
-	token := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
-	if token != h.secretToken {
-		slog.Error("invalid token", "token", token)
-		w.WriteHeader(http.StatusUnauthorized)
-		return
+// HandleUpdate processes a single update regardless of
+// whether it came from the webhook or from long polling.
+func (h *Handler) HandleUpdate(ctx context.Context, update Update) error {
+	switch {
+	case update.Message != nil:
+		return h.handleMessage(ctx, update.Message)
+	case update.EditedMessage != nil:
+		return h.handleEditedMessage(ctx, update.EditedMessage)
+	case update.InlineQuery != nil:
+		return h.handleInlineQuery(ctx, update.InlineQuery)
+	case update.CallbackQuery != nil:
+		return h.handleCallbackQuery(ctx, update.CallbackQuery)
+	default:
+		slog.Debug("skip unsupported update", "update_id", update.UpdateID)
+		return nil
 	}
+}
 
-	b, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "cant read body", http.StatusInternalServerError)
-		return
-	}
-	message := &MessageReq{}
-	err = json.Unmarshal(b, message)
-	if err != nil {
-		slog.Error("cant decode payload",
-			"error", err.Error(),
-			"payload", string(b),
-		)
-		http.Error(w, "cant parse body", http.StatusBadRequest)
-		return
-	}
-	if !slices.Contains(h.userWhiteList, message.Message.Chat.Username) {
+func (h *Handler) handleMessage(ctx context.Context, message *Message) error {
+	if !h.isAllowed(message) {
 		slog.Error(
 			"user not in white list",
-			"username", message.Message.Chat.Username,
-			"white_list", h.userWhiteList,
+			"chat_id", message.Chat.ID,
+			"user_id", message.senderID(),
+			"white_list", h.whiteList,
 		)
-		w.WriteHeader(http.StatusOK)
-		return
+		return nil
 	}
+	h.forumTopics.resolve(message)
 
-	ctx := r.Context()
-	newMessage, err := h.bot.SendMessage(ctx, message.Message.Chat.ID, "thinking...")
-	if err != nil {
-		slog.Error("send message",
-			"error", err.Error(),
-			"payload", string(b),
-		)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	if message.Document != nil {
+		return h.saveFile(ctx, message)
 	}
 
-	sb := &strings.Builder{}
-	ha := func(ctx context.Context, chunk []byte) error {
-		sb.Write(chunk)
-		_, err = h.bot.UpdateMessage(
-			ctx,
-			message.Message.Chat.ID,
-			newMessage.MessageID,
-			sb.String(),
-		)
+	source, fileID := models.SourceText, ""
+	if len(message.Photo) > 0 {
+		described, err := h.describePhoto(ctx, message)
+		if err != nil {
+			if h.isAddressed(message) {
+				_ = h.reply(ctx, message, textPhotoFailed)
+			}
+			return fmt.Errorf("photo message: %w", err)
+		}
+		fileID = message.Photo[len(message.Photo)-1].FileID
+		message = described
+		source = models.SourcePhoto
+	} else if _, _, ok := message.voiceFile(); ok && h.transcriber != nil {
+		transcribed, err := h.transcribe(ctx, message)
+		if err != nil {
+			if h.isAddressed(message) {
+				_ = h.reply(ctx, message, textVoiceFailed)
+			}
+			return fmt.Errorf("voice message: %w", err)
+		}
+		message = transcribed
+		source = models.SourceVoice
+	}
+
+	if cmd, args, ok := h.commands.parse(message.Text, h.me.Username); ok {
+		switch {
+		case cmd != nil:
+			return cmd.handle(ctx, message, args)
+		case h.isAddressed(message):
+			return h.reply(ctx, message, textUnknownCommand)
+		default:
+			// A command for another bot.
+			return nil
+		}
+	}
+
+	if message.Text == "" {
+		slog.Debug("skip message without text", "message_id", message.MessageID)
+		return nil
+	}
+
+	businessMessage := message.toBuisnessModel()
+	businessMessage.Text = h.stripMention(businessMessage.Text)
+	businessMessage.Source = source
+	businessMessage.FileID = fileID
+
+	if !h.isAddressed(message) {
+		// Group messages that are not meant for the bot
+		// are only remembered, without any reply.
+		_, err := h.chatService.HandleQuery(ctx, businessMessage, func(context.Context, []byte) error {
+			return nil
+		})
 		if err != nil {
-			return fmt.Errorf("update message: %w", err)
+			return fmt.Errorf("handle query: %w", err)
 		}
 		return nil
 	}
 
-	err = h.chatService.HandleQuery(
+	if !h.followUp(message, &businessMessage) {
+		message.scopeToDocument(&businessMessage)
+	}
+	return h.answer(ctx, message.Chat.ID, businessMessage)
+}
+
+// answer passes the message to the chat service and streams the
+// answer into a placeholder message, which gets the answer buttons.
+func (h *Handler) answer(ctx context.Context, chatID int64, message models.Message) error {
+	newMessage, err := h.bot.SendMessage(ctx, chatID, textThinking, WithThread(message.ThreadID))
+	if err != nil {
+		return fmt.Errorf("send message: %w", err)
+	}
+
+	typing := startTyping(ctx, h.bot, chatID, message.ThreadID)
+	defer typing.Stop()
+
+	ctx, gen, done := h.generations.start(ctx, chatID)
+	defer done()
+	id := h.answers.add(&answer{
+		chatID:   chatID,
+		question: message,
+		gen:      gen,
+	})
+
+	w := newMessageWriter(h.bot, chatID, newMessage.MessageID)
+	w.threadID = message.ThreadID
+	ctx = chat.WithProgress(ctx, func(ctx context.Context, stage chat.Stage) {
+		if text, ok := stageTexts[stage]; ok {
+			w.Stage(ctx, text)
+		}
+	})
+
+	result, err := h.chatService.HandleQuery(
 		ctx,
-		message.toBuisnessModel(),
-		ha,
+		message,
+		func(ctx context.Context, chunk []byte) error {
+			typing.Stop()
+			w.markup = stopKeyboard(id)
+			return w.Write(ctx, chunk)
+		},
 	)
+	typing.Stop()
+	if err != nil {
+		// Let the user know even if ctx is already cancelled.
+		failCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), failureNoticeTimeout)
+		defer cancel()
 
+		if gen.stopped.Load() {
+			// Keep the partial answer, it can be regenerated.
+			w.markup = answerKeyboard(id, false)
+			err = w.Fail(failCtx, textStopped)
+			h.answers.bind(id, chatID, w.messageIDs)
+			if err != nil {
+				return fmt.Errorf("show stopped: %w", err)
+			}
+			return nil
+		}
+
+		h.answers.remove(id)
+		w.markup = nil
+		if failErr := w.Fail(failCtx, textFailed); failErr != nil {
+			slog.Error("show failure", "error", failErr.Error(), "chat_id", chatID)
+		}
+		return fmt.Errorf("handle query: %w", err)
+	}
+
+	if result.Text == "" && w.String() == "" {
+		h.answers.remove(id)
+		w.markup = nil
+		err = w.Write(ctx, []byte(textSaved))
+		if err != nil {
+			return fmt.Errorf("show saved: %w", err)
+		}
+	} else {
+		h.answers.finish(id, result.Sources)
+		w.markup = answerKeyboard(id, len(result.Sources) > 0)
+	}
+
+	err = w.Close(ctx)
+	h.answers.bind(id, chatID, w.messageIDs)
 	if err != nil {
-		slog.Error("handle query",
-			"error", err.Error(),
-			"payload", string(b),
-		)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return fmt.Errorf("flush answer: %w", err)
 	}
 
-	w.WriteHeader(http.StatusOK)
+	// Show the photo the answer is about.
+	if len(result.Sources) > 0 {
+		err = h.sendPhotos(ctx, chatID, sourcePhotos(result.Sources[:1]), w.messageID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,168 @@
+package telegram
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"tgpt/internal/chat"
+)
+
+const (
+	// Telegram sends a new query on every keystroke, so the
+	// recall starts only once the user stopped typing.
+	inlineDebounce      = 700 * time.Millisecond
+	inlineAnswerTimeout = 8 * time.Second
+	inlineCacheTime     = 5 * time.Minute
+	inlineDescription   = 100
+)
+
+const (
+	textInlineTimeout = "No answer in time, try a shorter question."
+	textInlineFailed  = "Could not recall anything, try again."
+)
+
+type cachedAnswer struct {
+	text    string
+	expires time.Time
+}
+
+// inlineQueries runs at most one recall per user and
+// keeps the answers for repeated queries.
+type inlineQueries struct {
+	mu      sync.Mutex
+	running map[int64]context.CancelFunc
+	cache   map[string]cachedAnswer
+}
+
+func newInlineQueries() *inlineQueries {
+	return &inlineQueries{
+		running: map[int64]context.CancelFunc{},
+		cache:   map[string]cachedAnswer{},
+	}
+}
+
+// start cancels the previous query of the user.
+func (q *inlineQueries) start(ctx context.Context, userID int64) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), inlineAnswerTimeout)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if prev, ok := q.running[userID]; ok {
+		prev()
+	}
+	q.running[userID] = cancel
+	return ctx, cancel
+}
+
+func (q *inlineQueries) get(key string) (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	for k, a := range q.cache {
+		if now.After(a.expires) {
+			delete(q.cache, k)
+		}
+	}
+	a, ok := q.cache[key]
+	return a.text, ok
+}
+
+func (q *inlineQueries) put(key, text string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.cache[key] = cachedAnswer{text: text, expires: time.Now().Add(inlineCacheTime)}
+}
+
+// handleInlineQuery answers in the background, so a slow recall does
+// not hold up the worker and newer queries of the user can cancel it.
+func (h *Handler) handleInlineQuery(ctx context.Context, query *InlineQuery) error {
+	// Inline queries are answered from the sender's private memories.
+	message := &Message{
+		Chat: Chat{ID: query.From.ID, Type: ChatTypePrivate},
+		From: &query.From,
+		Text: strings.TrimSpace(query.Query),
+	}
+	if !h.isAllowed(message) {
+		slog.Error("user not in white list", "user_id", query.From.ID)
+		return nil
+	}
+	if message.Text == "" {
+		return nil
+	}
+
+	ctx, cancel := h.inline.start(ctx, query.From.ID)
+	go func() {
+		defer cancel()
+		h.answerInlineQuery(ctx, query.ID, message)
+	}()
+	return nil
+}
+
+func (h *Handler) answerInlineQuery(ctx context.Context, queryID string, message *Message) {
+	key := message.businessKey()
+	reply, ok := h.inline.get(key)
+	if !ok {
+		err := sleepCtx(ctx, inlineDebounce)
+		if err != nil {
+			// A newer query of the same user replaced this one.
+			return
+		}
+
+		var result chat.Answer
+		result, err = h.chatService.Recall(ctx, message.toBuisnessModel())
+		reply = result.Text
+		switch {
+		case ctx.Err() == context.Canceled:
+			return
+		case ctx.Err() == context.DeadlineExceeded:
+			reply = textInlineTimeout
+		case err != nil:
+			slog.Error("inline answer", "error", err.Error(), "user_id", message.Chat.ID)
+			reply = textInlineFailed
+		default:
+			h.inline.put(key, reply)
+		}
+	}
+
+	text, _ := splitMessage(reply, maxMessageLength)
+	id := sha1.Sum([]byte(key))
+	results := []InlineQueryResultArticle{{
+		Type:        "article",
+		ID:          hex.EncodeToString(id[:]),
+		Title:       message.Text,
+		Description: truncate(reply, inlineDescription),
+		InputMessageContent: InputTextMessageContent{
+			MessageText: renderHTML(text),
+			ParseMode:   ParseModeHTML,
+		},
+	}}
+
+	// The query may have been cancelled by the timeout,
+	// but answering it is still worth a try.
+	answerCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), failureNoticeTimeout)
+	defer cancel()
+	err := h.bot.AnswerInlineQuery(answerCtx, queryID, results, inlineCacheTime)
+	if err != nil {
+		slog.Error("reply inline query", "error", err.Error(), "user_id", message.Chat.ID)
+	}
+}
+
+// businessKey identifies the question a message asks.
+func (m Message) businessKey() string {
+	b := m.toBuisnessModel()
+	return b.UserName.String() + "|" + b.Topic + "|" + b.Text
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
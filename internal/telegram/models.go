@@ -2,76 +2,412 @@ package telegram
 
 import (
 	"encoding/json"
-	"slices"
+	"fmt"
 	"strings"
 	"time"
+	"unicode"
 
 	"tgpt/internal/models"
 )
 
 type APIResponse struct {
-	Ok          bool            `json:"ok"`
-	Result      json.RawMessage `json:"result,omitempty"`
-	ErrorCode   int             `json:"error_code,omitempty"`
-	Description string          `json:"description,omitempty"`
+	Ok          bool                `json:"ok"`
+	Result      json.RawMessage     `json:"result,omitempty"`
+	ErrorCode   int                 `json:"error_code,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Parameters  *ResponseParameters `json:"parameters,omitempty"`
 }
 
+type ResponseParameters struct {
+	MigrateToChatID int64 `json:"migrate_to_chat_id,omitempty"`
+	RetryAfter      int   `json:"retry_after,omitempty"`
+}
+
+// APIError is returned when Telegram answers with ok=false.
+type APIError struct {
+	Code        int
+	Description string
+	// RetryAfter is set when the request was rate limited.
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("api error: %s", e.Description)
+}
+
+// IsNotModified reports whether an edit was rejected
+// because the new text equals the current one.
+func (e *APIError) IsNotModified() bool {
+	return strings.Contains(e.Description, "message is not modified")
+}
+
+// IsParseError reports whether Telegram could not parse
+// the formatting entities of the text.
+func (e *APIError) IsParseError() bool {
+	return strings.Contains(e.Description, "can't parse entities")
+}
+
+// Update is an incoming update. At most one of the optional
+// fields is present in any given update.
+type Update struct {
+	UpdateID          int64              `json:"update_id"`
+	Message           *Message           `json:"message,omitempty"`
+	EditedMessage     *Message           `json:"edited_message,omitempty"`
+	ChannelPost       *Message           `json:"channel_post,omitempty"`
+	EditedChannelPost *Message           `json:"edited_channel_post,omitempty"`
+	InlineQuery       *InlineQuery       `json:"inline_query,omitempty"`
+	CallbackQuery     *CallbackQuery     `json:"callback_query,omitempty"`
+	MyChatMember      *ChatMemberUpdated `json:"my_chat_member,omitempty"`
+}
+
+type User struct {
+	ID           int64  `json:"id"`
+	IsBot        bool   `json:"is_bot"`
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name,omitempty"`
+	Username     string `json:"username,omitempty"`
+	LanguageCode string `json:"language_code,omitempty"`
+	IsPremium    bool   `json:"is_premium,omitempty"`
+}
+
+type Chat struct {
+	ID        int64  `json:"id"`
+	Type      string `json:"type"`
+	Title     string `json:"title,omitempty"`
+	Username  string `json:"username,omitempty"`
+	FirstName string `json:"first_name,omitempty"`
+	LastName  string `json:"last_name,omitempty"`
+	IsForum   bool   `json:"is_forum,omitempty"`
+}
+
+const (
+	ChatTypePrivate    = "private"
+	ChatTypeGroup      = "group"
+	ChatTypeSupergroup = "supergroup"
+	ChatTypeChannel    = "channel"
+)
+
 type Message struct {
-	Date int `json:"date"`
-	Chat struct {
-		LastName  string `json:"last_name"`
-		ID        string `json:"id"`
-		Type      string `json:"type"`
-		FirstName string `json:"first_name"`
-		Username  string `json:"username"`
-	} `json:"chat"`
-	MessageID string `json:"message_id"`
-	From      struct {
-		LastName  string `json:"last_name"`
-		ID        int64  `json:"id"`
-		FirstName string `json:"first_name"`
-		Username  string `json:"username"`
-	} `json:"from"`
-	Text string `json:"text"`
-}
-
-type MessageReq struct {
-	UpdateID int     `json:"update_id"`
-	Message  Message `json:"message"`
-}
-
-func (m MessageReq) toBuisnessModel() models.Message {
-	split := strings.Split(m.Message.Text, " ")
-	var (
-		command string
-		topic   string
-
-		commandIdx int
-		topicIdx   int
-	)
-	for _, word := range split {
-		if strings.HasPrefix(word, "!") {
-			command = word
-		}
+	MessageID       int64           `json:"message_id"`
+	MessageThreadID int64           `json:"message_thread_id,omitempty"`
+	From            *User           `json:"from,omitempty"`
+	SenderChat      *Chat           `json:"sender_chat,omitempty"`
+	Date            int64           `json:"date"`
+	Chat            Chat            `json:"chat"`
+	IsTopicMessage  bool            `json:"is_topic_message,omitempty"`
+	ReplyToMessage  *Message        `json:"reply_to_message,omitempty"`
+	ForwardOrigin   *MessageOrigin  `json:"forward_origin,omitempty"`
+	EditDate        int64           `json:"edit_date,omitempty"`
+	Text            string          `json:"text,omitempty"`
+	Entities        []MessageEntity `json:"entities,omitempty"`
+	Caption         string          `json:"caption,omitempty"`
+	CaptionEntities []MessageEntity `json:"caption_entities,omitempty"`
+	Photo           []PhotoSize     `json:"photo,omitempty"`
+	Audio           *Audio          `json:"audio,omitempty"`
+	Document        *Document       `json:"document,omitempty"`
+	Video           *Video          `json:"video,omitempty"`
+	Voice           *Voice          `json:"voice,omitempty"`
+
+	ForumTopicCreated *ForumTopicCreated `json:"forum_topic_created,omitempty"`
+	ForumTopicEdited  *ForumTopicEdited  `json:"forum_topic_edited,omitempty"`
+
+	// forumTopicName is the name of the forum topic the message was
+	// sent to, if known. It is filled in by forumTopics.
+	forumTopicName string
+}
+
+// Types of MessageOrigin.
+const (
+	OriginUser       = "user"
+	OriginHiddenUser = "hidden_user"
+	OriginChat       = "chat"
+	OriginChannel    = "channel"
+)
+
+// MessageOrigin describes where a forwarded message was originally sent.
+type MessageOrigin struct {
+	Type            string `json:"type"`
+	Date            int64  `json:"date"`
+	SenderUser      *User  `json:"sender_user,omitempty"`
+	SenderUserName  string `json:"sender_user_name,omitempty"`
+	SenderChat      *Chat  `json:"sender_chat,omitempty"`
+	Chat            *Chat  `json:"chat,omitempty"`
+	MessageID       int64  `json:"message_id,omitempty"`
+	AuthorSignature string `json:"author_signature,omitempty"`
+}
 
-		if strings.HasPrefix(word, "#") {
-			topic = word
+// name is the original author as people would call it.
+func (o MessageOrigin) name() string {
+	var name string
+	switch o.Type {
+	case OriginUser:
+		if o.SenderUser != nil {
+			name = userName(*o.SenderUser)
 		}
+	case OriginHiddenUser:
+		name = o.SenderUserName
+	case OriginChat:
+		if o.SenderChat != nil {
+			name = chatName(*o.SenderChat)
+		}
+	case OriginChannel:
+		if o.Chat != nil {
+			name = chatName(*o.Chat)
+		}
+	}
+	if o.AuthorSignature != "" {
+		name = strings.TrimSpace(name + " (" + o.AuthorSignature + ")")
+	}
+	return name
+}
+
+func userName(u User) string {
+	if u.Username != "" {
+		return u.Username
+	}
+	return strings.TrimSpace(u.FirstName + " " + u.LastName)
+}
+
+func chatName(c Chat) string {
+	if c.Title != "" {
+		return c.Title
 	}
-	split = slices.Delete(split, commandIdx, commandIdx+1)
-	split = slices.Delete(split, topicIdx, topicIdx+1)
-	text := strings.Join(split, " ")
+	return c.Username
+}
+
+type MessageEntity struct {
+	Type     string `json:"type"`
+	Offset   int    `json:"offset"`
+	Length   int    `json:"length"`
+	URL      string `json:"url,omitempty"`
+	User     *User  `json:"user,omitempty"`
+	Language string `json:"language,omitempty"`
+}
+
+type PhotoSize struct {
+	FileID       string `json:"file_id"`
+	FileUniqueID string `json:"file_unique_id"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	FileSize     int64  `json:"file_size,omitempty"`
+}
+
+type Audio struct {
+	FileID       string `json:"file_id"`
+	FileUniqueID string `json:"file_unique_id"`
+	Duration     int    `json:"duration"`
+	Performer    string `json:"performer,omitempty"`
+	Title        string `json:"title,omitempty"`
+	FileName     string `json:"file_name,omitempty"`
+	MimeType     string `json:"mime_type,omitempty"`
+	FileSize     int64  `json:"file_size,omitempty"`
+}
+
+type Document struct {
+	FileID       string     `json:"file_id"`
+	FileUniqueID string     `json:"file_unique_id"`
+	Thumbnail    *PhotoSize `json:"thumbnail,omitempty"`
+	FileName     string     `json:"file_name,omitempty"`
+	MimeType     string     `json:"mime_type,omitempty"`
+	FileSize     int64      `json:"file_size,omitempty"`
+}
+
+type Video struct {
+	FileID       string     `json:"file_id"`
+	FileUniqueID string     `json:"file_unique_id"`
+	Width        int        `json:"width"`
+	Height       int        `json:"height"`
+	Duration     int        `json:"duration"`
+	Thumbnail    *PhotoSize `json:"thumbnail,omitempty"`
+	FileName     string     `json:"file_name,omitempty"`
+	MimeType     string     `json:"mime_type,omitempty"`
+	FileSize     int64      `json:"file_size,omitempty"`
+}
 
+type Voice struct {
+	FileID       string `json:"file_id"`
+	FileUniqueID string `json:"file_unique_id"`
+	Duration     int    `json:"duration"`
+	MimeType     string `json:"mime_type,omitempty"`
+	FileSize     int64  `json:"file_size,omitempty"`
+}
+
+type File struct {
+	FileID       string `json:"file_id"`
+	FileUniqueID string `json:"file_unique_id"`
+	FileSize     int64  `json:"file_size,omitempty"`
+	FilePath     string `json:"file_path,omitempty"`
+}
+
+type InlineQuery struct {
+	ID       string `json:"id"`
+	From     User   `json:"from"`
+	Query    string `json:"query"`
+	Offset   string `json:"offset"`
+	ChatType string `json:"chat_type,omitempty"`
+}
+
+type InlineQueryResultArticle struct {
+	Type                string                  `json:"type"`
+	ID                  string                  `json:"id"`
+	Title               string                  `json:"title"`
+	Description         string                  `json:"description,omitempty"`
+	InputMessageContent InputTextMessageContent `json:"input_message_content"`
+}
+
+type InputTextMessageContent struct {
+	MessageText string `json:"message_text"`
+	ParseMode   string `json:"parse_mode,omitempty"`
+}
+
+type InlineKeyboardMarkup struct {
+	InlineKeyboard [][]InlineKeyboardButton `json:"inline_keyboard"`
+}
+
+type InlineKeyboardButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data,omitempty"`
+	URL          string `json:"url,omitempty"`
+}
+
+type BotCommand struct {
+	Command     string `json:"command"`
+	Description string `json:"description"`
+}
+
+type CallbackQuery struct {
+	ID              string   `json:"id"`
+	From            User     `json:"from"`
+	Message         *Message `json:"message,omitempty"`
+	InlineMessageID string   `json:"inline_message_id,omitempty"`
+	ChatInstance    string   `json:"chat_instance"`
+	Data            string   `json:"data,omitempty"`
+}
+
+type ChatMemberUpdated struct {
+	Chat          Chat       `json:"chat"`
+	From          User       `json:"from"`
+	Date          int64      `json:"date"`
+	OldChatMember ChatMember `json:"old_chat_member"`
+	NewChatMember ChatMember `json:"new_chat_member"`
+}
+
+type ChatMember struct {
+	Status string `json:"status"`
+	User   User   `json:"user"`
+}
+
+// toBuisnessModel converts the message to the chat service model.
+// The first #hashtag sets the topic and is removed from the text,
+// the command is set by the caller.
+func (m Message) toBuisnessModel() models.Message {
+	text, topic := cutHashtag(m.Text)
+
+	// Hashtags override the forum topic.
+	if topic == "" {
+		topic = m.forumTopic()
+	}
 	if topic == "" {
 		topic = "#default"
 	}
 
-	return models.Message{
-		TimeSend:     time.Now(),
-		UserName:     models.UserID{ID: models.ID(m.Message.Chat.Username)},
-		FromUserName: models.UserID{ID: models.ID(m.Message.From.Username)},
+	// Date is when the message was sent, it stays the same when the
+	// message is edited or its update is delivered again.
+	sent := time.Unix(m.Date, 0)
+	if m.Date == 0 {
+		sent = time.Now()
+	}
+
+	message := models.Message{
+		ChatID:       m.Chat.ID,
+		MessageID:    m.MessageID,
+		ThreadID:     m.threadID(),
+		TimeSend:     sent,
+		UserName:     models.NewUserID(m.Chat.ID, m.senderID()),
+		FromUserName: models.UserID{ID: models.ID(m.senderName())},
 		Text:         text,
 		Topic:        topic,
-		Command:      command,
+		TopicName:    m.forumTopicName,
+		Source:       models.SourceText,
+	}
+	if r := m.repliedMessage(); r != nil {
+		quoted := r.Text
+		if quoted == "" {
+			quoted = r.Caption
+		}
+		if quoted != "" {
+			message.ReplyTo = &models.Quote{From: r.senderName(), Text: quoted}
+		}
+	}
+	if o := m.ForwardOrigin; o != nil {
+		message.Origin = &models.Origin{
+			Type: o.Type,
+			Name: o.name(),
+			Date: time.Unix(o.Date, 0),
+		}
+	}
+	return message
+}
+
+// cutHashtag removes the first word starting with # from the text.
+// The word itself is removed, not the first occurrence of its text,
+// which may be a part of another word like C#dev.
+func cutHashtag(text string) (rest, hashtag string) {
+	for start := 0; start < len(text); {
+		end := strings.IndexFunc(text[start:], unicode.IsSpace)
+		if end < 0 {
+			end = len(text)
+		} else {
+			end += start
+		}
+
+		word := text[start:end]
+		if strings.HasPrefix(word, "#") && len(word) > 1 {
+			// Keep line breaks around the word, join the rest with a space.
+			before := strings.TrimRight(text[:start], " \t")
+			after := strings.TrimLeft(text[end:], " \t")
+			sep := " "
+			switch {
+			case strings.HasSuffix(before, "\n") && strings.HasPrefix(after, "\n"):
+				// The word was on a line of its own.
+				sep, after = "", after[1:]
+			case strings.HasSuffix(before, "\n") || strings.HasPrefix(after, "\n"):
+				sep = ""
+			}
+			return strings.TrimSpace(before + sep + after), word
+		}
+
+		next := strings.IndexFunc(text[end:], func(r rune) bool { return !unicode.IsSpace(r) })
+		if next < 0 {
+			break
+		}
+		start = end + next
+	}
+	return text, ""
+}
+
+// senderID is the user who sent the message, the chat on whose
+// behalf it was sent for anonymous admins and channels. SenderChat
+// comes first: for those messages Telegram sets From to a placeholder
+// like GroupAnonymousBot, shared by every anonymous sender.
+func (m Message) senderID() int64 {
+	switch {
+	case m.SenderChat != nil:
+		return m.SenderChat.ID
+	case m.From != nil:
+		return m.From.ID
+	default:
+		return m.Chat.ID
+	}
+}
+
+func (m Message) senderName() string {
+	switch {
+	case m.SenderChat != nil:
+		return m.SenderChat.Title
+	case m.From != nil:
+		return userName(*m.From)
+	default:
+		return m.Chat.Title
 	}
 }
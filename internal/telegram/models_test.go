@@ -0,0 +1,132 @@
+package telegram
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"tgpt/internal/models"
+)
+
+func TestUpdateRoundTrip(t *testing.T) {
+	files, err := filepath.Glob("testdata/updates/*.json")
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			raw, err := os.ReadFile(file)
+			require.NoError(t, err)
+
+			var update Update
+			require.NoError(t, json.Unmarshal(raw, &update))
+
+			encoded, err := json.Marshal(update)
+			require.NoError(t, err)
+			require.JSONEq(t, string(raw), string(encoded))
+		})
+	}
+}
+
+func TestUpdateFields(t *testing.T) {
+	read := func(t *testing.T, name string) Update {
+		raw, err := os.ReadFile(filepath.Join("testdata/updates", name))
+		require.NoError(t, err)
+		var update Update
+		require.NoError(t, json.Unmarshal(raw, &update))
+		return update
+	}
+
+	t.Run("message ids are integers", func(t *testing.T) {
+		u := read(t, "message_reply.json")
+		require.Equal(t, int64(912345001), u.UpdateID)
+		require.Equal(t, int64(4211), u.Message.MessageID)
+		require.Equal(t, int64(184467440), u.Message.Chat.ID)
+		require.Equal(t, int64(4209), u.Message.ReplyToMessage.MessageID)
+		require.Len(t, u.Message.Entities, 2)
+	})
+
+	t.Run("reply context", func(t *testing.T) {
+		u := read(t, "message_reply.json")
+		m := u.Message.toBuisnessModel()
+		require.Equal(t, &models.Quote{From: "tgpt_bot", Text: "You were in Dubai this autumn."}, m.ReplyTo)
+	})
+
+	t.Run("time sent is the message date", func(t *testing.T) {
+		u := read(t, "edited_message.json")
+		m := u.EditedMessage.toBuisnessModel()
+		require.Equal(t, time.Unix(1727704900, 0), m.TimeSend, "not the edit date")
+	})
+
+	t.Run("negative chat ids", func(t *testing.T) {
+		u := read(t, "channel_post.json")
+		require.Nil(t, u.Message)
+		require.Equal(t, int64(-1001987654321), u.ChannelPost.Chat.ID)
+	})
+
+	t.Run("forum photo", func(t *testing.T) {
+		u := read(t, "forum_photo.json")
+		require.Equal(t, int64(37), u.Message.MessageThreadID)
+		require.True(t, u.Message.IsTopicMessage)
+		require.Len(t, u.Message.Photo, 2)
+	})
+	t.Run("forward origin", func(t *testing.T) {
+		u := read(t, "forwarded_channel.json")
+		m := u.Message.toBuisnessModel()
+		require.Equal(t, "#go", m.Topic)
+		require.Equal(t, "s1kai", m.FromUserName.String())
+		require.NotNil(t, m.Origin)
+		require.Equal(t, OriginChannel, m.Origin.Type)
+		require.Equal(t, "Go Weekly (Anna)", m.Origin.Name)
+		require.Equal(t, int64(1727600000), m.Origin.Date.Unix())
+	})
+}
+
+func TestCutHashtag(t *testing.T) {
+	for _, tc := range []struct {
+		text, rest, hashtag string
+	}{
+		{"#travel dubai was amazing", "dubai was amazing", "#travel"},
+		{"dubai #travel was amazing", "dubai was amazing", "#travel"},
+		{"C#dev is cool #dev", "C#dev is cool", "#dev"},
+		{"first line\n#travel\nlast line", "first line\nlast line", "#travel"},
+		{"no # topic", "no # topic", ""},
+	} {
+		rest, hashtag := cutHashtag(tc.text)
+		require.Equal(t, tc.rest, rest, tc.text)
+		require.Equal(t, tc.hashtag, hashtag, tc.text)
+	}
+}
+
+func TestSender(t *testing.T) {
+	group := Chat{ID: -100, Type: ChatTypeSupergroup, Title: "team"}
+
+	for _, tc := range []struct {
+		name     string
+		message  Message
+		wantID   int64
+		wantName string
+	}{
+		{"user", Message{Chat: group, From: &User{ID: 7, FirstName: "Ann"}}, 7, "Ann"},
+		{"anonymous admin", Message{
+			Chat:       group,
+			From:       &User{ID: 1087968824, IsBot: true, FirstName: "Group", Username: "GroupAnonymousBot"},
+			SenderChat: &group,
+		}, -100, "team"},
+		{"channel post", Message{
+			Chat:       group,
+			From:       &User{ID: 777000, FirstName: "Telegram"},
+			SenderChat: &Chat{ID: -200, Type: ChatTypeChannel, Title: "news"},
+		}, -200, "news"},
+		{"no sender", Message{Chat: group}, -100, "team"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.wantID, tc.message.senderID())
+			require.Equal(t, tc.wantName, tc.message.senderName())
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// OffsetStore persists the getUpdates offset between restarts.
+type OffsetStore interface {
+	LoadOffset() (int64, error)
+	SaveOffset(offset int64) error
+}
+
+type FileOffsetStore struct {
+	path string
+}
+
+func NewFileOffsetStore(path string) *FileOffsetStore {
+	return &FileOffsetStore{path: path}
+}
+
+// LoadOffset returns zero if nothing was saved yet.
+func (s *FileOffsetStore) LoadOffset() (int64, error) {
+	b, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("read file: %w", err)
+	}
+
+	offset, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse offset: %w", err)
+	}
+	return offset, nil
+}
+
+func (s *FileOffsetStore) SaveOffset(offset int64) error {
+	return writeFileAtomic(s.path, []byte(strconv.FormatInt(offset, 10)))
+}
+
+// writeFileAtomic writes data to a temporary file and renames it,
+// so a crash never leaves a truncated file behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("write: %w", err)
+	}
+	err = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("close temp file: %w", err)
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+	return nil
+}
+
+type memoryOffsetStore struct {
+	offset int64
+}
+
+func (s *memoryOffsetStore) LoadOffset() (int64, error) {
+	return s.offset, nil
+}
+
+func (s *memoryOffsetStore) SaveOffset(offset int64) error {
+	s.offset = offset
+	return nil
+}
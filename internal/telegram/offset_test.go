@@ -0,0 +1,129 @@
+package telegram_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"tgpt/internal/telegram"
+	"tgpt/internal/telegram/telegramtest"
+)
+
+func TestFileOffsetStore(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "offset")
+		require.NoError(t, telegram.NewFileOffsetStore(path).SaveOffset(42))
+
+		offset, err := telegram.NewFileOffsetStore(path).LoadOffset()
+		require.NoError(t, err)
+		require.Equal(t, int64(42), offset)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		offset, err := telegram.NewFileOffsetStore(filepath.Join(t.TempDir(), "offset")).LoadOffset()
+		require.NoError(t, err)
+		require.Zero(t, offset)
+	})
+
+	t.Run("corrupt file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "offset")
+		require.NoError(t, os.WriteFile(path, []byte("forty two"), 0o600))
+
+		_, err := telegram.NewFileOffsetStore(path).LoadOffset()
+		require.Error(t, err)
+	})
+}
+
+// updateRecorder records the handled update ids and runs fn on every update.
+type updateRecorder struct {
+	mu  sync.Mutex
+	ids []int64
+	fn  func(ctx context.Context, update telegram.Update) error
+}
+
+func (h *updateRecorder) HandleUpdate(ctx context.Context, update telegram.Update) error {
+	h.mu.Lock()
+	h.ids = append(h.ids, update.UpdateID)
+	h.mu.Unlock()
+	if h.fn == nil {
+		return nil
+	}
+	return h.fn(ctx, update)
+}
+
+func (h *updateRecorder) handled() []int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return slices.Clone(h.ids)
+}
+
+func runPoller(t *testing.T, p *telegram.Poller) (context.CancelFunc, <-chan error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() { done <- p.Run(ctx) }()
+	return cancel, done
+}
+
+func TestPoller(t *testing.T) {
+	t.Run("advances and persists the offset", func(t *testing.T) {
+		srv := telegramtest.NewServer(t)
+		offsets := telegram.NewFileOffsetStore(filepath.Join(t.TempDir(), "offset"))
+		for range 3 {
+			srv.PushUpdate(telegram.Update{})
+		}
+
+		h := &updateRecorder{}
+		cancel, done := runPoller(t, telegram.NewPoller(srv.Bot(), h, offsets, time.Second))
+		require.Eventually(t, func() bool {
+			offset, err := offsets.LoadOffset()
+			return err == nil && offset == 4
+		}, 5*time.Second, 10*time.Millisecond)
+		cancel()
+		require.NoError(t, <-done)
+		require.Equal(t, []int64{1, 2, 3}, h.handled())
+
+		// A restarted poller continues from the saved offset.
+		srv = telegramtest.NewServer(t)
+		srv.PushUpdate(telegram.Update{UpdateID: 3})
+		srv.PushUpdate(telegram.Update{UpdateID: 4})
+		h = &updateRecorder{}
+		cancel, done = runPoller(t, telegram.NewPoller(srv.Bot(), h, offsets, time.Second))
+		require.Eventually(t, func() bool { return len(h.handled()) > 0 }, 5*time.Second, 10*time.Millisecond)
+		cancel()
+		require.NoError(t, <-done)
+		require.Equal(t, []int64{4}, h.handled())
+	})
+
+	t.Run("keeps the offset of an update not handed over", func(t *testing.T) {
+		srv := telegramtest.NewServer(t)
+		offsets := telegram.NewFileOffsetStore(filepath.Join(t.TempDir(), "offset"))
+		srv.PushUpdate(telegram.Update{})
+		srv.PushUpdate(telegram.Update{})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		h := &updateRecorder{fn: func(ctx context.Context, update telegram.Update) error {
+			if update.UpdateID < 2 {
+				return nil
+			}
+			// Shutdown while the update waits for a queue slot.
+			cancel()
+			<-ctx.Done()
+			return ctx.Err()
+		}}
+		require.NoError(t, telegram.NewPoller(srv.Bot(), h, offsets, time.Second).Run(ctx))
+		require.Equal(t, []int64{1, 2}, h.handled())
+
+		offset, err := offsets.LoadOffset()
+		require.NoError(t, err)
+		require.Equal(t, int64(2), offset)
+	})
+}
@@ -0,0 +1,98 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"tgpt/internal/chat"
+	"tgpt/internal/models"
+)
+
+// maxPhotoSize caps photo downloads, Telegram compresses photos well below it.
+const maxPhotoSize = 10 << 20
+
+const (
+	textPhotoPrefix = "[photo]"
+	textPhotoFailed = "⚠️ Could not process the photo."
+)
+
+// describePhoto replaces a photo message with a text message holding its
+// caption and a description made by the vision model. The photo is still
+// remembered by its caption if there is no vision model.
+func (h *Handler) describePhoto(ctx context.Context, message *Message) (*Message, error) {
+	// Sizes are sorted from the smallest to the largest.
+	photo := message.Photo[len(message.Photo)-1]
+
+	description, err := h.photoDescription(ctx, message, photo)
+	if err != nil {
+		return nil, err
+	}
+
+	text := message.Caption
+	if text != "" {
+		text += "\n"
+	}
+	text += textPhotoPrefix
+	if description != "" {
+		text += " " + description
+	}
+
+	described := *message
+	described.Text = text
+	return &described, nil
+}
+
+func (h *Handler) photoDescription(ctx context.Context, message *Message, photo PhotoSize) (string, error) {
+	err := h.bot.SendChatAction(ctx, message.Chat.ID, ChatActionTyping, WithThread(message.threadID()))
+	if err != nil {
+		slog.Warn("send chat action", "error", err.Error(), "chat_id", message.Chat.ID)
+	}
+
+	image, err := h.downloadFile(ctx, photo.FileID, maxPhotoSize)
+	if err != nil {
+		return "", err
+	}
+
+	// Telegram re-encodes all photos to JPEG.
+	description, err := h.chatService.DescribeImage(ctx, "image/jpeg", image)
+	if errors.Is(err, chat.ErrVisionDisabled) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("describe image: %w", err)
+	}
+	return description, nil
+}
+
+// sourcePhotos returns the file ids of the photos among the sources.
+func sourcePhotos(sources []chat.Source) []string {
+	var (
+		ids  []string
+		seen = map[string]bool{}
+	)
+	for _, src := range sources {
+		if kind, _ := src.Metadata["source"].(string); kind != models.SourcePhoto {
+			continue
+		}
+		id, _ := src.Metadata["file_id"].(string)
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// sendPhotos resends the stored photos as replies to the message.
+func (h *Handler) sendPhotos(ctx context.Context, chatID int64, fileIDs []string, replyTo int64) error {
+	for _, id := range fileIDs {
+		_, err := h.bot.SendPhoto(ctx, chatID, id, "", WithReplyTo(replyTo))
+		if err != nil {
+			return fmt.Errorf("send photo: %w", err)
+		}
+	}
+	return nil
+}
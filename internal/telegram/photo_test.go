@@ -0,0 +1,26 @@
+package telegram
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"tgpt/internal/chat"
+	"tgpt/internal/models"
+)
+
+func TestSourcePhotos(t *testing.T) {
+	photo := func(fileID string) chat.Source {
+		return chat.Source{Metadata: map[string]any{"source": models.SourcePhoto, "file_id": fileID}}
+	}
+
+	sources := []chat.Source{
+		photo("a"),
+		{Metadata: map[string]any{"source": models.SourceText}},
+		photo("b"),
+		photo("a"),
+		{Metadata: map[string]any{"source": models.SourcePhoto}},
+	}
+	require.Equal(t, []string{"a", "b"}, sourcePhotos(sources))
+	require.Empty(t, sourcePhotos(nil))
+}
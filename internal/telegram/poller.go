@@ -0,0 +1,88 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const pollerRetryDelay = 3 * time.Second
+
+type updateHandler interface {
+	HandleUpdate(ctx context.Context, update Update) error
+}
+
+// Poller receives updates through getUpdates and feeds them
+// into the same processing path as the webhook.
+type Poller struct {
+	bot     *Bot
+	handler updateHandler
+	offsets OffsetStore
+	timeout time.Duration
+}
+
+// NewPoller creates a poller. If offsets is nil the offset
+// is kept in memory only.
+func NewPoller(
+	bot *Bot,
+	handler updateHandler,
+	offsets OffsetStore,
+	timeout time.Duration,
+) *Poller {
+	if offsets == nil {
+		offsets = &memoryOffsetStore{}
+	}
+	return &Poller{
+		bot:     bot,
+		handler: handler,
+		offsets: offsets,
+		timeout: timeout,
+	}
+}
+
+// Run polls until ctx is done. The offset is saved after every
+// handed over update, so a restart neither replays nor drops updates.
+func (p *Poller) Run(ctx context.Context) error {
+	offset, err := p.offsets.LoadOffset()
+	if err != nil {
+		return fmt.Errorf("load offset: %w", err)
+	}
+
+	for {
+		updates, err := p.bot.GetUpdates(ctx, offset, p.timeout)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			slog.Error("get updates", "error", err.Error(), "offset", offset)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(pollerRetryDelay):
+			}
+			continue
+		}
+
+		for _, update := range updates {
+			err = p.handler.HandleUpdate(ctx, update)
+			if ctx.Err() != nil {
+				// The update was not handed over, keep the offset
+				// so it is received again after restart.
+				return nil
+			}
+			if err != nil {
+				slog.Error("handle update",
+					"error", err.Error(),
+					"update_id", update.UpdateID,
+				)
+			}
+
+			offset = update.UpdateID + 1
+			err = p.offsets.SaveOffset(offset)
+			if err != nil {
+				slog.Error("save offset", "error", err.Error(), "offset", offset)
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package telegram
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"tgpt/internal/chat"
+)
+
+// Telegram shows a chat action for five seconds or until a message is sent.
+const typingInterval = 4 * time.Second
+
+const failureNoticeTimeout = 10 * time.Second
+
+const (
+	textThinking = "thinking..."
+	textSaved    = "saved"
+	textFailed   = "⚠️ Something went wrong, please try again."
+)
+
+var stageTexts = map[chat.Stage]string{
+	chat.StageSaving:     "saving...",
+	chat.StageRetrieving: "retrieving memories...",
+	chat.StageGenerating: "generating...",
+}
+
+type chatActionSender interface {
+	SendChatAction(ctx context.Context, chatID int64, action string, opts ...MessageOption) error
+}
+
+// typingIndicator keeps the typing action visible until stopped.
+type typingIndicator struct {
+	stop chan struct{}
+	once sync.Once
+	done chan struct{}
+}
+
+func startTyping(ctx context.Context, bot chatActionSender, chatID, threadID int64) *typingIndicator {
+	t := &typingIndicator{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(t.done)
+		ticker := time.NewTicker(typingInterval)
+		defer ticker.Stop()
+
+		for {
+			err := bot.SendChatAction(ctx, chatID, ChatActionTyping, WithThread(threadID))
+			if err != nil {
+				slog.Warn("send chat action", "error", err.Error(), "chat_id", chatID)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return t
+}
+
+// Stop is safe to call more than once.
+func (t *typingIndicator) Stop() {
+	t.once.Do(func() {
+		close(t.stop)
+	})
+	<-t.done
+}
@@ -0,0 +1,171 @@
+package telegram
+
+import (
+	"strings"
+	"unicode/utf16"
+)
+
+// Telegram limits message text to 4096 UTF-16 code units.
+const maxMessageLength = 4096
+
+const codeFence = "```"
+
+const (
+	breakWord = iota
+	breakSentence
+	breakLine
+	breakParagraph
+)
+
+type breakPoint struct {
+	pos      int
+	priority int
+	// fence is the opening fence line if pos is inside a code block.
+	fence string
+}
+
+// splitMessage cuts text into a head that fits into limit and the rest.
+// It prefers paragraph, then line, then sentence, then word boundaries,
+// never cuts inside inline formatting and cuts a code block only when
+// there is no other way, closing it in head and reopening it in rest.
+func splitMessage(text string, limit int) (head, rest string) {
+	if textLength(text) <= limit {
+		return text, ""
+	}
+
+	// Leave room for the fence that may have to be closed.
+	limit -= len("\n" + codeFence)
+
+	var (
+		points []breakPoint
+		length int
+
+		fence   string
+		hardCut int
+		// inlineEnd is where the inline formatting entity
+		// the text is in ends, there are no break points before.
+		inlineEnd int
+	)
+
+	for i, r := range text {
+		if length+utf16.RuneLen(r) > limit {
+			break
+		}
+		length += utf16.RuneLen(r)
+		hardCut = i + len(string(r))
+
+		lineStart := i == 0 || text[i-1] == '\n'
+		switch {
+		case lineStart && strings.HasPrefix(text[i:], codeFence):
+			if fence == "" {
+				end := strings.IndexByte(text[i:], '\n')
+				if end < 0 {
+					end = len(text) - i
+				}
+				fence = text[i : i+end]
+			} else {
+				fence = ""
+			}
+		case fence != "" || i < inlineEnd:
+		case strings.ContainsRune("`[*_~", r):
+			if n := inlineSpan(text, i); n > 0 {
+				inlineEnd = i + n
+			}
+		}
+
+		next := i + len(string(r))
+		switch {
+		case r == '\n' && fence != "":
+			// Do not cut right after the opening fence line.
+			if !strings.HasPrefix(text[next-len(fence)-1:], fence) {
+				points = append(points, breakPoint{pos: next, priority: breakLine, fence: fence})
+			}
+		case fence != "" || next < inlineEnd:
+		case r == '\n' && strings.HasSuffix(text[:next], "\n\n"):
+			points = append(points, breakPoint{pos: next, priority: breakParagraph})
+		case r == '\n':
+			points = append(points, breakPoint{pos: next, priority: breakLine})
+		case r == ' ' && next >= 2 && strings.ContainsAny(text[next-2:next-1], ".!?"):
+			points = append(points, breakPoint{pos: next, priority: breakSentence})
+		case r == ' ':
+			points = append(points, breakPoint{pos: next, priority: breakWord})
+		}
+	}
+
+	p, ok := bestBreakPoint(points, hardCut/2)
+	if !ok {
+		return text[:hardCut], text[hardCut:]
+	}
+
+	head, rest = text[:p.pos], text[p.pos:]
+	if p.fence != "" {
+		head = strings.TrimRight(head, "\n") + "\n" + codeFence
+		rest = p.fence + "\n" + rest
+	}
+	return head, rest
+}
+
+// bestBreakPoint picks the latest point of the highest priority that
+// is not before minPos. Points outside code blocks always win.
+func bestBreakPoint(points []breakPoint, minPos int) (breakPoint, bool) {
+	outside := points[:0:0]
+	for _, p := range points {
+		if p.fence == "" {
+			outside = append(outside, p)
+		}
+	}
+	if len(outside) > 0 {
+		points = outside
+	}
+	if len(points) == 0 {
+		return breakPoint{}, false
+	}
+
+	for priority := breakParagraph; priority >= breakWord; priority-- {
+		for i := len(points) - 1; i >= 0; i-- {
+			if points[i].priority == priority && points[i].pos >= minPos {
+				return points[i], true
+			}
+		}
+	}
+	return points[len(points)-1], true
+}
+
+// inlineSpan returns the length of the inline code, link or emphasis
+// starting at text[i], zero if there is none. Entities are recognized
+// the way renderHTML does, within a single line, so markup that is
+// never closed, like the "[1]" of a citation, does not stop splitting.
+func inlineSpan(text string, i int) int {
+	lineStart := strings.LastIndexByte(text[:i], '\n') + 1
+	lineEnd := len(text)
+	if n := strings.IndexByte(text[i:], '\n'); n >= 0 {
+		lineEnd = i + n
+	}
+	line, rest := text[lineStart:lineEnd], text[i:lineEnd]
+	if rest == "" {
+		return 0
+	}
+
+	switch rest[0] {
+	case '`':
+		if end := strings.IndexByte(rest[1:], '`'); end > 0 {
+			return end + 2
+		}
+	case '[':
+		if _, _, n, ok := parseLink(rest); ok {
+			return n
+		}
+	}
+	if _, _, n, ok := parseEmphasis(line, i-lineStart); ok {
+		return n
+	}
+	return 0
+}
+
+func textLength(text string) int {
+	n := 0
+	for _, r := range text {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
@@ -0,0 +1,103 @@
+package telegram
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitMessage(t *testing.T) {
+	t.Run("fits", func(t *testing.T) {
+		head, rest := splitMessage("short", 10)
+		require.Equal(t, "short", head)
+		require.Empty(t, rest)
+	})
+
+	t.Run("prefers paragraphs", func(t *testing.T) {
+		text := strings.Repeat("a", 30) + ". " + strings.Repeat("b", 10) + "\n\n" + strings.Repeat("c", 40)
+		head, rest := splitMessage(text, 60)
+		require.Equal(t, strings.Repeat("a", 30)+". "+strings.Repeat("b", 10)+"\n\n", head)
+		require.Equal(t, strings.Repeat("c", 40), rest)
+	})
+
+	t.Run("falls back to sentences", func(t *testing.T) {
+		text := "First sentence here. Second one goes on and on and on"
+		head, rest := splitMessage(text, 40)
+		require.Equal(t, "First sentence here. ", head)
+		require.Equal(t, "Second one goes on and on and on", rest)
+	})
+
+	t.Run("does not cut inside bold", func(t *testing.T) {
+		text := "some words **very bold text that is long** end"
+		head, _ := splitMessage(text, 35)
+		require.Equal(t, "some words ", head)
+	})
+
+	t.Run("does not cut inside italics and links", func(t *testing.T) {
+		text := "intro. *an italic phrase here* and [a link](https://example.com) end"
+		head, _ := splitMessage(text, 30)
+		require.Equal(t, "intro. ", head)
+
+		head, _ = splitMessage(text, 55)
+		require.Equal(t, "intro. *an italic phrase here* and ", head)
+	})
+
+	t.Run("ignores markup that is never closed", func(t *testing.T) {
+		text := "intro. see [1] for details, it goes on and on and on and on and on"
+		head, rest := splitMessage(text, 60)
+		require.Equal(t, "intro. see [1] for details, it goes on and on and on ", head)
+		require.Equal(t, "and on and on", rest)
+
+		text = "snake_case and a * b and file_name go on and on and on and on and on"
+		head, _ = splitMessage(text, 60)
+		require.Equal(t, "snake_case and a * b and file_name go on and on and on ", head)
+	})
+
+	t.Run("moves code block to the next message", func(t *testing.T) {
+		text := "intro line\n```go\nfmt.Println(1)\nfmt.Println(2)\n```\n"
+		head, rest := splitMessage(text, 40)
+		require.Equal(t, "intro line\n", head)
+		require.Equal(t, "```go\nfmt.Println(1)\nfmt.Println(2)\n```\n", rest)
+	})
+
+	t.Run("reopens a code block that is too long", func(t *testing.T) {
+		text := "```go\n" + strings.Repeat("x := 1\n", 10) + "```"
+		head, rest := splitMessage(text, 40)
+		require.True(t, strings.HasPrefix(head, "```go\n"))
+		require.True(t, strings.HasSuffix(head, "\n```"))
+		require.LessOrEqual(t, textLength(head), 40)
+		require.True(t, strings.HasPrefix(rest, "```go\nx := 1\n"))
+	})
+
+	t.Run("counts utf-16 code units", func(t *testing.T) {
+		text := strings.Repeat("😀", 10)
+		head, rest := splitMessage(text, 14)
+		require.Equal(t, strings.Repeat("😀", 5), head)
+		require.Equal(t, strings.Repeat("😀", 5), rest)
+	})
+}
+
+func TestMessageWriterRollsOver(t *testing.T) {
+	ctx := context.Background()
+	bot := newFakeEditor()
+	w := newTestWriter(bot, &fakeClock{})
+	w.limit = 30
+
+	for _, word := range strings.Fields("one two three four five six seven eight nine ten eleven twelve") {
+		require.NoError(t, w.Write(ctx, []byte(word+" ")))
+	}
+	require.NoError(t, w.Close(ctx))
+
+	require.Len(t, bot.sent, 2)
+	var texts []string
+	texts = append(texts, bot.texts[1])
+	for _, id := range bot.sent {
+		texts = append(texts, bot.texts[id])
+	}
+	for _, text := range texts {
+		require.LessOrEqual(t, textLength(text), 30)
+	}
+	require.Equal(t, w.String(), strings.Join(texts, ""))
+}
@@ -0,0 +1,359 @@
+// Package telegramtest provides an in-process fake Telegram Bot API
+// server for end-to-end tests of bots built on package telegram.
+package telegramtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tgpt/internal/telegram"
+)
+
+// Token is the bot token the fake server accepts.
+const Token = "123456:TEST"
+
+// waitTimeout bounds how long Wait waits for calls.
+const waitTimeout = 5 * time.Second
+
+// Call is a recorded Bot API request.
+type Call struct {
+	Method string
+	Params map[string]string
+	// Files are the uploaded files by field name.
+	Files map[string][]byte
+}
+
+// Markup decodes the reply_markup parameter of the call.
+func (c Call) Markup() *telegram.InlineKeyboardMarkup {
+	raw, ok := c.Params["reply_markup"]
+	if !ok {
+		return nil
+	}
+	var m telegram.InlineKeyboardMarkup
+	if err := json.Unmarshal([]byte(raw), &m); err != nil {
+		return nil
+	}
+	return &m
+}
+
+// Server is a fake Bot API server. It records every call, answers
+// sendMessage and editMessageText with the resulting message, serves
+// pushed updates through getUpdates and files through getFile.
+// Other methods succeed with true unless a response is set with Respond.
+type Server struct {
+	// Me is returned by getMe.
+	Me telegram.User
+
+	t   testing.TB
+	srv *httptest.Server
+
+	mu        sync.Mutex
+	changed   chan struct{}
+	calls     []Call
+	updates   []telegram.Update
+	nextID    int64
+	files     map[string][]byte
+	responses map[string]json.RawMessage
+}
+
+// NewServer starts a fake server, which is closed when the test ends.
+func NewServer(t testing.TB) *Server {
+	s := &Server{
+		Me:        telegram.User{ID: 123456, IsBot: true, FirstName: "tgpt", Username: "tgpt_bot"},
+		t:         t,
+		changed:   make(chan struct{}),
+		nextID:    1,
+		files:     map[string][]byte{},
+		responses: map[string]json.RawMessage{},
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.srv.Close)
+	return s
+}
+
+// URL is the API URL to pass to telegram.NewBot.
+func (s *Server) URL() string {
+	return s.srv.URL
+}
+
+// Bot returns a bot talking to the server.
+func (s *Server) Bot() *telegram.Bot {
+	return telegram.NewBot(s.srv.Client(), s.srv.URL, Token)
+}
+
+// PushUpdate queues the update for getUpdates. Update ids
+// are assigned in order if the update has none.
+func (s *Server) PushUpdate(update telegram.Update) telegram.Update {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if update.UpdateID == 0 {
+		update.UpdateID = int64(len(s.updates) + 1)
+	}
+	s.updates = append(s.updates, update)
+	s.notifyLocked()
+	return update
+}
+
+// AddFile makes the file downloadable through getFile.
+func (s *Server) AddFile(fileID string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[fileID] = data
+}
+
+// Respond sets the result returned for every call of the method.
+func (s *Server) Respond(method string, result any) {
+	raw, err := json.Marshal(result)
+	if err != nil {
+		s.t.Fatalf("marshal %s response: %v", method, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses[method] = raw
+}
+
+// Calls returns the recorded calls of the method, or all calls if method is empty.
+func (s *Server) Calls(method string) []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.callsLocked(method)
+}
+
+// Wait waits until there are n calls of the method matching the filter,
+// a nil filter matches all of them, and returns the matching calls.
+func (s *Server) Wait(method string, n int, filter func(Call) bool) []Call {
+	s.t.Helper()
+	deadline := time.After(waitTimeout)
+	for {
+		s.mu.Lock()
+		var matched []Call
+		for _, c := range s.callsLocked(method) {
+			if filter == nil || filter(c) {
+				matched = append(matched, c)
+			}
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		if len(matched) >= n {
+			return matched
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			s.t.Fatalf("got %d %s calls, want %d; all calls: %v", len(matched), method, n, s.Calls(""))
+			return nil
+		}
+	}
+}
+
+// PostWebhook delivers the update to a webhook handler the way Telegram does.
+func PostWebhook(t testing.TB, h http.Handler, secretToken string, update telegram.Update) *http.Response {
+	t.Helper()
+	body, err := json.Marshal(update)
+	if err != nil {
+		t.Fatalf("marshal update: %v", err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-Telegram-Bot-Api-Secret-Token", secretToken)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Result()
+}
+
+func (s *Server) callsLocked(method string) []Call {
+	var calls []Call
+	for _, c := range s.calls {
+		if method == "" || c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// notifyLocked wakes up everyone waiting for calls or updates.
+func (s *Server) notifyLocked() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if file, ok := strings.CutPrefix(r.URL.Path, "/file/bot"+Token+"/"); ok {
+		s.serveFile(w, file)
+		return
+	}
+	method, ok := strings.CutPrefix(r.URL.Path, "/bot"+Token+"/")
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	call, err := parseCall(r, method)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+		return
+	}
+
+	// getUpdates is polled in a loop, recording it would
+	// only make the calls harder to read.
+	if method == "getUpdates" {
+		s.serveUpdates(r.Context(), w, call)
+		return
+	}
+
+	s.mu.Lock()
+	s.calls = append(s.calls, call)
+	s.notifyLocked()
+	result, err := s.resultLocked(call)
+	s.mu.Unlock()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+		return
+	}
+	writeResult(w, result)
+}
+
+func parseCall(r *http.Request, method string) (Call, error) {
+	call := Call{Method: method, Params: map[string]string{}, Files: map[string][]byte{}}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		return call, nil
+	}
+
+	err := r.ParseMultipartForm(32 << 20)
+	if err != nil {
+		return Call{}, err
+	}
+	for k, v := range r.MultipartForm.Value {
+		call.Params[k] = v[0]
+	}
+	for k, fhs := range r.MultipartForm.File {
+		f, err := fhs[0].Open()
+		if err != nil {
+			return Call{}, err
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return Call{}, err
+		}
+		call.Files[k] = data
+	}
+	return call, nil
+}
+
+func (s *Server) resultLocked(call Call) (any, error) {
+	if raw, ok := s.responses[call.Method]; ok {
+		return raw, nil
+	}
+
+	switch call.Method {
+	case "getMe":
+		return s.Me, nil
+	case "sendMessage", "sendPhoto", "editMessageText":
+		chatID, err := strconv.ParseInt(call.Params["chat_id"], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("chat_id: %w", err)
+		}
+		messageID := s.nextID
+		if call.Method == "editMessageText" {
+			messageID, err = strconv.ParseInt(call.Params["message_id"], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("message_id: %w", err)
+			}
+		} else {
+			s.nextID++
+		}
+		return telegram.Message{
+			MessageID: messageID,
+			From:      &s.Me,
+			Chat:      telegram.Chat{ID: chatID},
+			Date:      time.Now().Unix(),
+			Text:      call.Params["text"],
+			Caption:   call.Params["caption"],
+		}, nil
+	case "getFile":
+		id := call.Params["file_id"]
+		data, ok := s.files[id]
+		if !ok {
+			return nil, fmt.Errorf("wrong file_id")
+		}
+		return telegram.File{
+			FileID:       id,
+			FileUniqueID: id,
+			FileSize:     int64(len(data)),
+			FilePath:     "files/" + id,
+		}, nil
+	default:
+		return true, nil
+	}
+}
+
+// serveUpdates answers getUpdates with the updates from offset,
+// holding the request for up to timeout if there are none.
+func (s *Server) serveUpdates(ctx context.Context, w http.ResponseWriter, call Call) {
+	offset, _ := strconv.ParseInt(call.Params["offset"], 10, 64)
+	timeout, _ := strconv.Atoi(call.Params["timeout"])
+	deadline := time.After(time.Duration(timeout) * time.Second)
+
+	for {
+		s.mu.Lock()
+		var updates []telegram.Update
+		for _, u := range s.updates {
+			if u.UpdateID >= offset {
+				updates = append(updates, u)
+			}
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		if len(updates) > 0 {
+			writeResult(w, updates)
+			return
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			writeResult(w, []telegram.Update{})
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, path string) {
+	s.mu.Lock()
+	data, ok := s.files[strings.TrimPrefix(path, "files/")]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, nil)
+		return
+	}
+	_, _ = w.Write(data)
+}
+
+func writeResult(w http.ResponseWriter, result any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": result})
+}
+
+func writeError(w http.ResponseWriter, code int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"ok":          false,
+		"error_code":  code,
+		"description": description,
+	})
+}
@@ -0,0 +1,78 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"unicode"
+)
+
+const textVoiceFailed = "⚠️ Could not recognize the voice message."
+
+// maxVoiceSize is the size of the largest audio file transcribed,
+// the limit of the OpenAI transcription API.
+const maxVoiceSize = 25 << 20
+
+type transcriber interface {
+	Transcribe(ctx context.Context, fileName string, audio io.Reader) (string, error)
+}
+
+// voiceFile returns the file to transcribe, if the message has one.
+func (m Message) voiceFile() (fileID, fileName string, ok bool) {
+	switch {
+	case m.Voice != nil:
+		return m.Voice.FileID, "voice.ogg", true
+	case m.Audio != nil && m.Audio.FileName != "":
+		return m.Audio.FileID, m.Audio.FileName, true
+	case m.Audio != nil:
+		return m.Audio.FileID, "audio.mp3", true
+	default:
+		return "", "", false
+	}
+}
+
+// transcribe replaces a voice message with a text message holding its
+// caption followed by the transcript. A transcript that starts with the
+// spoken trigger of a command, like "bro, where was I in May?", becomes
+// that command. Other transcripts are saved as they are, so ordinary
+// speech like "stop by the pharmacy" is never taken for a command.
+func (h *Handler) transcribe(ctx context.Context, message *Message) (*Message, error) {
+	fileID, fileName, _ := message.voiceFile()
+
+	err := h.bot.SendChatAction(ctx, message.Chat.ID, ChatActionTyping, WithThread(message.threadID()))
+	if err != nil {
+		slog.Warn("send chat action", "error", err.Error(), "chat_id", message.Chat.ID)
+	}
+
+	audio, _, err := h.downloadTempFile(ctx, fileID, min(h.bot.MaxFileSize(), maxVoiceSize))
+	if err != nil {
+		return nil, err
+	}
+	defer removeTempFile(audio)
+
+	transcript, err := h.transcriber.Transcribe(ctx, fileName, audio)
+	if err != nil {
+		return nil, fmt.Errorf("transcribe: %w", err)
+	}
+
+	if first, rest, _ := strings.Cut(transcript, " "); message.Caption == "" {
+		name := strings.ToLower(strings.TrimFunc(first, func(r rune) bool {
+			return !unicode.IsLetter(r)
+		}))
+		if cmd, ok := h.commands.byVoice[name]; ok {
+			transcript = "/" + cmd.name + " " + rest
+		}
+	}
+
+	text := message.Caption
+	if text != "" {
+		text += "\n"
+	}
+	text += transcript
+
+	transcribed := *message
+	transcribed.Text = text
+	return &transcribed, nil
+}
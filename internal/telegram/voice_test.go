@@ -0,0 +1,85 @@
+package telegram
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type transcriberFunc func(fileName string, audio []byte) string
+
+func (f transcriberFunc) Transcribe(_ context.Context, fileName string, audio io.Reader) (string, error) {
+	data, err := io.ReadAll(audio)
+	if err != nil {
+		return "", err
+	}
+	return f(fileName, data), nil
+}
+
+func TestTranscribe(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/botTOKEN/getFile":
+			path := "voice/file_1.oga"
+			if r.FormValue("file_id") == "large" {
+				path = "voice/large.oga"
+			}
+			_, _ = w.Write([]byte(`{"ok":true,"result":{"file_id":"v1","file_unique_id":"u1","file_path":"` + path + `"}}`))
+		case "/file/botTOKEN/voice/file_1.oga":
+			_, _ = w.Write([]byte("audio"))
+		case "/file/botTOKEN/voice/large.oga":
+			_, _ = w.Write(make([]byte, maxVoiceSize+1))
+		default:
+			_, _ = w.Write([]byte(`{"ok":true,"result":true}`))
+		}
+	}))
+	defer srv.Close()
+
+	var transcript string
+	h := NewHandler(nil, NewBot(srv.Client(), srv.URL, "TOKEN"), transcriberFunc(func(fileName string, audio []byte) string {
+		require.Equal(t, "voice.ogg", fileName)
+		require.Equal(t, "audio", string(audio))
+		return transcript
+	}), User{}, nil, 0)
+
+	for _, tc := range []struct {
+		caption    string
+		transcript string
+		text       string
+	}{
+		{"", "Bro, where was I in May?", "/ask where was I in May?"},
+		{"", "Бро где я был в мае?", "/ask где я был в мае?"},
+		{"", "Help me remember to call mom", "Help me remember to call mom"},
+		{"", "Start the deploy at five", "Start the deploy at five"},
+		{"", "Stop by the pharmacy", "Stop by the pharmacy"},
+		{"", "Ask Anna about visas", "Ask Anna about visas"},
+		{"", "Forget it, the meeting is off", "Forget it, the meeting is off"},
+		{"#travel", "bro where was I?", "#travel\nbro where was I?"},
+	} {
+		t.Run(tc.transcript, func(t *testing.T) {
+			transcript = tc.transcript
+			message := &Message{
+				MessageID: 1,
+				Chat:      Chat{ID: 1, Type: ChatTypePrivate},
+				Caption:   tc.caption,
+				Voice:     &Voice{FileID: "v1"},
+			}
+			transcribed, err := h.transcribe(context.Background(), message)
+			require.NoError(t, err)
+			require.Equal(t, tc.text, transcribed.Text)
+		})
+	}
+
+	// Files over the limit are not sent for transcription.
+	transcript = "never"
+	_, err := h.transcribe(context.Background(), &Message{
+		MessageID: 2,
+		Chat:      Chat{ID: 1, Type: ChatTypePrivate},
+		Voice:     &Voice{FileID: "large"},
+	})
+	require.ErrorContains(t, err, "file is over")
+}
@@ -0,0 +1,192 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type WebhookConfig struct {
+	URL                string
+	SecretToken        string
+	AllowedUpdates     []string
+	MaxConnections     int
+	DropPendingUpdates bool
+	// CertificatePath points to a self-signed public key in PEM format.
+	CertificatePath string
+}
+
+type WebhookInfo struct {
+	URL                  string   `json:"url"`
+	HasCustomCertificate bool     `json:"has_custom_certificate"`
+	PendingUpdateCount   int      `json:"pending_update_count"`
+	IPAddress            string   `json:"ip_address,omitempty"`
+	LastErrorDate        int64    `json:"last_error_date,omitempty"`
+	LastErrorMessage     string   `json:"last_error_message,omitempty"`
+	MaxConnections       int      `json:"max_connections,omitempty"`
+	AllowedUpdates       []string `json:"allowed_updates,omitempty"`
+}
+
+// RegisterWebhook makes the registered webhook match cfg. Differences
+// found before registration are logged as drift, differences that are
+// still there afterwards are returned as an error.
+func RegisterWebhook(ctx context.Context, bot *Bot, cfg WebhookConfig) error {
+	info, err := bot.GetWebhookInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("get webhook info: %w", err)
+	}
+	for _, d := range webhookDrift(cfg, info) {
+		slog.Warn("webhook drift", "field", d.field, "configured", d.configured, "registered", d.registered)
+	}
+
+	err = bot.SetWebhook(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("set webhook: %w", err)
+	}
+
+	info, err = bot.GetWebhookInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("get webhook info: %w", err)
+	}
+	if drift := webhookDrift(cfg, info); len(drift) > 0 {
+		fields := make([]string, 0, len(drift))
+		for _, d := range drift {
+			fields = append(fields, d.field)
+		}
+		return fmt.Errorf("webhook still differs after registration: %s", strings.Join(fields, ", "))
+	}
+	if info.LastErrorMessage != "" {
+		slog.Warn("webhook last error",
+			"message", info.LastErrorMessage,
+			"date", time.Unix(info.LastErrorDate, 0),
+		)
+	}
+
+	slog.Info("webhook registered",
+		"url", info.URL,
+		"pending_update_count", info.PendingUpdateCount,
+	)
+	return nil
+}
+
+type driftEntry struct {
+	field      string
+	configured string
+	registered string
+}
+
+func webhookDrift(cfg WebhookConfig, info WebhookInfo) []driftEntry {
+	var drift []driftEntry
+	if cfg.URL != info.URL {
+		drift = append(drift, driftEntry{"url", cfg.URL, info.URL})
+	}
+	if cfg.MaxConnections > 0 && cfg.MaxConnections != info.MaxConnections {
+		drift = append(drift, driftEntry{
+			"max_connections",
+			strconv.Itoa(cfg.MaxConnections),
+			strconv.Itoa(info.MaxConnections),
+		})
+	}
+	if len(cfg.AllowedUpdates) > 0 && !sameSet(cfg.AllowedUpdates, info.AllowedUpdates) {
+		drift = append(drift, driftEntry{
+			"allowed_updates",
+			fmt.Sprint(cfg.AllowedUpdates),
+			fmt.Sprint(info.AllowedUpdates),
+		})
+	}
+	if (cfg.CertificatePath != "") != info.HasCustomCertificate {
+		drift = append(drift, driftEntry{
+			"has_custom_certificate",
+			strconv.FormatBool(cfg.CertificatePath != ""),
+			strconv.FormatBool(info.HasCustomCertificate),
+		})
+	}
+	return drift
+}
+
+// sameSet reports whether a and b hold the same values,
+// regardless of their order and repetitions.
+func sameSet(a, b []string) bool {
+	set := func(s []string) []string {
+		s = slices.Clone(s)
+		slices.Sort(s)
+		return slices.Compact(s)
+	}
+	return slices.Equal(set(a), set(b))
+}
+
+// ParseAllowedUpdates parses a comma separated list of update
+// types, like "message, callback_query".
+func ParseAllowedUpdates(raw string) []string {
+	var updates []string
+	for _, u := range strings.Split(raw, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			updates = append(updates, u)
+		}
+	}
+	return updates
+}
+
+const webhookEnqueueTimeout = 5 * time.Second
+
+// WebhookHandler is the webhook endpoint. It checks the secret token
+// and hands the decoded update over without waiting for processing.
+type WebhookHandler struct {
+	secretToken string
+	updates     updateHandler
+}
+
+func NewWebhookHandler(secretToken string, updates updateHandler) *WebhookHandler {
+	return &WebhookHandler{
+		secretToken: secretToken,
+		updates:     updates,
+	}
+}
+
+func (wh *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+	if token != wh.secretToken {
+		slog.Error("invalid token", "token", token)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "cant read body", http.StatusInternalServerError)
+		return
+	}
+	update := &Update{}
+	err = json.Unmarshal(b, update)
+	if err != nil {
+		slog.Error("cant decode payload",
+			"error", err.Error(),
+			"payload", string(b),
+		)
+		http.Error(w, "cant parse body", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), webhookEnqueueTimeout)
+	defer cancel()
+
+	err = wh.updates.HandleUpdate(ctx, *update)
+	if err != nil {
+		// Telegram redelivers the update later.
+		slog.Error("handle update",
+			"error", err.Error(),
+			"update_id", update.UpdateID,
+		)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,47 @@
+package telegram
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAllowedUpdates(t *testing.T) {
+	require.Equal(t, []string{"message", "callback_query"}, ParseAllowedUpdates("message, callback_query"))
+	require.Equal(t, []string{"message"}, ParseAllowedUpdates(" message ,,"))
+	require.Empty(t, ParseAllowedUpdates(""))
+}
+
+func TestSameSet(t *testing.T) {
+	require.True(t, sameSet([]string{"message", "callback_query"}, []string{"callback_query", "message"}))
+	require.True(t, sameSet([]string{"message", "message"}, []string{"message"}))
+	require.False(t, sameSet([]string{"message", "message"}, []string{"message", "callback_query"}))
+	require.False(t, sameSet([]string{"message"}, nil))
+}
+
+func TestWebhookDrift(t *testing.T) {
+	cfg := WebhookConfig{
+		URL:            "https://example.com/webhook",
+		AllowedUpdates: ParseAllowedUpdates("message, callback_query"),
+		MaxConnections: 10,
+	}
+	info := WebhookInfo{
+		URL:            "https://example.com/webhook",
+		AllowedUpdates: []string{"callback_query", "message"},
+		MaxConnections: 10,
+	}
+	require.Empty(t, webhookDrift(cfg, info))
+
+	info.MaxConnections = 40
+	info.AllowedUpdates = []string{"message"}
+	info.HasCustomCertificate = true
+	require.Equal(t, []driftEntry{
+		{"max_connections", "10", "40"},
+		{"allowed_updates", "[message callback_query]", "[message]"},
+		{"has_custom_certificate", "false", "true"},
+	}, webhookDrift(cfg, info))
+
+	// Unset options are whatever Telegram has.
+	info.HasCustomCertificate = false
+	require.Empty(t, webhookDrift(WebhookConfig{URL: cfg.URL}, info))
+}
@@ -0,0 +1,255 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const (
+	// Telegram allows about one edit per second in a chat.
+	defaultEditInterval = 1500 * time.Millisecond
+	// Pending text that is flushed without waiting for the interval.
+	defaultEditBudget = 1000
+	// How many times the final flush is retried on rate limiting.
+	maxFinalFlushAttempts = 5
+)
+
+type messageEditor interface {
+	SendMessage(
+		ctx context.Context,
+		chatID int64,
+		message string,
+		opts ...MessageOption,
+	) (Message, error)
+	UpdateMessage(
+		ctx context.Context,
+		chatID, messageID int64,
+		message string,
+		opts ...MessageOption,
+	) (Message, error)
+}
+
+// messageWriter streams generated text into a Telegram message.
+// Chunks are coalesced into edits on a time and size budget,
+// rate limits are waited out and edits that change nothing are skipped.
+// Text that does not fit into one message rolls over into follow-up
+// messages, and only the latest one is edited while streaming.
+// Markdown is rendered to HTML, falling back to plain text
+// when Telegram rejects the result.
+type messageWriter struct {
+	bot       messageEditor
+	chatID    int64
+	messageID int64
+	// messageIDs are all messages the text is written to.
+	messageIDs []int64
+	// threadID is the forum topic follow-up messages are sent to.
+	threadID int64
+
+	interval time.Duration
+	budget   int
+	limit    int
+
+	// markup is attached to the latest message.
+	markup     *InlineKeyboardMarkup
+	sentMarkup *InlineKeyboardMarkup
+
+	text strings.Builder
+	// tail is the text of the latest message, earlier
+	// messages are complete and never edited again.
+	tail         string
+	sent         string
+	lastEdit     time.Time
+	blockedUntil time.Time
+
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+func newMessageWriter(bot messageEditor, chatID, messageID int64) *messageWriter {
+	return &messageWriter{
+		bot:        bot,
+		chatID:     chatID,
+		messageID:  messageID,
+		messageIDs: []int64{messageID},
+		interval:   defaultEditInterval,
+		budget:     defaultEditBudget,
+		limit:      maxMessageLength,
+		now:        time.Now,
+		sleep:      sleepCtx,
+	}
+}
+
+// Write appends chunk and edits the message if the budget allows it.
+// It matches chat.Handler.
+func (w *messageWriter) Write(ctx context.Context, chunk []byte) error {
+	w.text.Write(chunk)
+	w.tail += string(chunk)
+
+	now := w.now()
+	if now.Before(w.blockedUntil) {
+		return nil
+	}
+	pending := len(w.tail) - len(w.sent)
+	if now.Sub(w.lastEdit) < w.interval && pending < w.budget {
+		return nil
+	}
+
+	err := w.flush(ctx)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		return nil
+	}
+	return err
+}
+
+// Close flushes the final text, waiting out rate limits if needed.
+func (w *messageWriter) Close(ctx context.Context) error {
+	for range maxFinalFlushAttempts {
+		if wait := w.blockedUntil.Sub(w.now()); wait > 0 {
+			err := w.sleep(ctx, wait)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := w.flush(ctx)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			continue
+		}
+		return err
+	}
+	return fmt.Errorf("final flush: still rate limited after %d attempts", maxFinalFlushAttempts)
+}
+
+// Stage shows a progress text in the message until the first chunk arrives.
+func (w *messageWriter) Stage(ctx context.Context, text string) {
+	if w.text.Len() > 0 || w.now().Before(w.blockedUntil) {
+		return
+	}
+	err := w.edit(ctx, text, nil)
+	if err != nil {
+		slog.Warn("show stage", "error", err.Error(), "chat_id", w.chatID)
+	}
+}
+
+// Fail appends notice to the written text, or replaces the
+// progress text with it if nothing was written, and flushes.
+func (w *messageWriter) Fail(ctx context.Context, notice string) error {
+	if w.text.Len() > 0 {
+		notice = "\n\n" + notice
+	}
+	w.text.WriteString(notice)
+	w.tail += notice
+	return w.Close(ctx)
+}
+
+// String returns all text written so far.
+func (w *messageWriter) String() string {
+	return w.text.String()
+}
+
+func (w *messageWriter) flush(ctx context.Context) error {
+	for {
+		head, rest := splitMessage(w.tail, w.limit)
+		last := strings.TrimSpace(rest) == ""
+
+		markup := w.markup
+		if !last {
+			markup = nil
+		}
+		err := w.edit(ctx, head, markup)
+		if err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+
+		next, _ := splitMessage(rest, w.limit)
+		msg, err := w.formatted(next, func(text string, opts ...MessageOption) (Message, error) {
+			opts = append(opts, WithReplyMarkup(w.markup), WithThread(w.threadID))
+			return w.bot.SendMessage(ctx, w.chatID, text, opts...)
+		})
+		if err != nil {
+			return w.handleError(err, "send message")
+		}
+		w.messageID = msg.MessageID
+		w.messageIDs = append(w.messageIDs, msg.MessageID)
+		w.tail = rest
+		w.sent = next
+		w.sentMarkup = w.markup
+		w.lastEdit = w.now()
+	}
+}
+
+func (w *messageWriter) edit(
+	ctx context.Context,
+	text string,
+	markup *InlineKeyboardMarkup,
+) error {
+	if strings.TrimSpace(text) == "" || (text == w.sent && markup == w.sentMarkup) {
+		return nil
+	}
+
+	w.lastEdit = w.now()
+	_, err := w.formatted(text, func(text string, opts ...MessageOption) (Message, error) {
+		opts = append(opts, WithReplyMarkup(markup))
+		return w.bot.UpdateMessage(ctx, w.chatID, w.messageID, text, opts...)
+	})
+	var apiErr *APIError
+	if err != nil && !(errors.As(err, &apiErr) && apiErr.IsNotModified()) {
+		return w.handleError(err, "update message")
+	}
+
+	w.sent = text
+	w.sentMarkup = markup
+	return nil
+}
+
+// formatted calls send with text rendered to HTML
+// and retries with plain text on entity parse errors.
+func (w *messageWriter) formatted(
+	text string,
+	send func(text string, opts ...MessageOption) (Message, error),
+) (Message, error) {
+	msg, err := send(renderHTML(text), WithParseMode(ParseModeHTML))
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.IsParseError() {
+		slog.Warn("fall back to plain text",
+			"chat_id", w.chatID,
+			"error", err.Error(),
+		)
+		return send(text)
+	}
+	return msg, err
+}
+
+func (w *messageWriter) handleError(err error, action string) error {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		slog.Warn("rate limited",
+			"action", action,
+			"chat_id", w.chatID,
+			"retry_after", apiErr.RetryAfter,
+		)
+		w.blockedUntil = w.now().Add(apiErr.RetryAfter)
+		return err
+	}
+	return fmt.Errorf("%s: %w", action, err)
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
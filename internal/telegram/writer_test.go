@@ -0,0 +1,156 @@
+package telegram
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeEditor struct {
+	nextID int64
+	// ids of sent messages in order of sending
+	sent []int64
+	// current text of every message by id
+	texts map[int64]string
+	edits int
+	// errs are returned by the next edits
+	errs []error
+}
+
+func newFakeEditor() *fakeEditor {
+	return &fakeEditor{nextID: 100, texts: map[int64]string{}}
+}
+
+func (f *fakeEditor) SendMessage(
+	_ context.Context,
+	_ int64,
+	text string,
+	_ ...MessageOption,
+) (Message, error) {
+	f.nextID++
+	f.sent = append(f.sent, f.nextID)
+	f.texts[f.nextID] = text
+	return Message{MessageID: f.nextID}, nil
+}
+
+func (f *fakeEditor) UpdateMessage(
+	_ context.Context,
+	_, messageID int64,
+	text string,
+	_ ...MessageOption,
+) (Message, error) {
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		if err != nil {
+			return Message{}, err
+		}
+	}
+	f.edits++
+	f.texts[messageID] = text
+	return Message{MessageID: messageID}, nil
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Sleep(_ context.Context, d time.Duration) error {
+	c.now = c.now.Add(d)
+	return nil
+}
+
+func newTestWriter(bot *fakeEditor, clock *fakeClock) *messageWriter {
+	w := newMessageWriter(bot, 1, 1)
+	w.now = clock.Now
+	w.sleep = clock.Sleep
+	return w
+}
+
+func TestMessageWriter(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("coalesces chunks", func(t *testing.T) {
+		bot := newFakeEditor()
+		clock := &fakeClock{now: time.Now()}
+		w := newTestWriter(bot, clock)
+
+		for _, chunk := range []string{"a", "b", "c"} {
+			require.NoError(t, w.Write(ctx, []byte(chunk)))
+		}
+		require.Equal(t, 1, bot.edits)
+
+		clock.now = clock.now.Add(defaultEditInterval)
+		require.NoError(t, w.Write(ctx, []byte("d")))
+		require.Equal(t, 2, bot.edits)
+
+		require.NoError(t, w.Close(ctx))
+		require.Equal(t, 2, bot.edits, "no-op edit must be skipped")
+		require.Equal(t, "abcd", bot.texts[1])
+	})
+
+	t.Run("honors retry_after", func(t *testing.T) {
+		bot := newFakeEditor()
+		bot.errs = []error{&APIError{Code: 429, Description: "Too Many Requests", RetryAfter: 5 * time.Second}}
+		clock := &fakeClock{now: time.Now()}
+		w := newTestWriter(bot, clock)
+
+		require.NoError(t, w.Write(ctx, []byte("a")))
+		clock.now = clock.now.Add(2 * defaultEditInterval)
+		require.NoError(t, w.Write(ctx, []byte("b")))
+		require.Equal(t, 0, bot.edits)
+
+		start := clock.now
+		require.NoError(t, w.Close(ctx))
+		require.Equal(t, "ab", bot.texts[1])
+		require.GreaterOrEqual(t, clock.now.Sub(start), 2*time.Second)
+	})
+
+	t.Run("not modified is not an error", func(t *testing.T) {
+		bot := newFakeEditor()
+		bot.errs = []error{&APIError{Code: 400, Description: "Bad Request: message is not modified"}}
+		w := newTestWriter(bot, &fakeClock{now: time.Now()})
+
+		require.NoError(t, w.Write(ctx, []byte("a")))
+		require.NoError(t, w.Close(ctx))
+	})
+
+	t.Run("stage until the first chunk", func(t *testing.T) {
+		bot := newFakeEditor()
+		clock := &fakeClock{now: time.Now()}
+		w := newTestWriter(bot, clock)
+
+		w.Stage(ctx, "searching")
+		require.Equal(t, "searching", bot.texts[1])
+
+		require.NoError(t, w.Write(ctx, []byte("answer")))
+		w.Stage(ctx, "thinking")
+		require.NoError(t, w.Close(ctx))
+		require.Equal(t, "answer", bot.texts[1])
+	})
+
+	t.Run("fail replaces the stage", func(t *testing.T) {
+		bot := newFakeEditor()
+		w := newTestWriter(bot, &fakeClock{now: time.Now()})
+
+		w.Stage(ctx, "searching")
+		require.NoError(t, w.Fail(ctx, "failed"))
+		require.Equal(t, "failed", bot.texts[1])
+		require.Equal(t, "failed", w.String())
+	})
+
+	t.Run("fail keeps the written text", func(t *testing.T) {
+		bot := newFakeEditor()
+		w := newTestWriter(bot, &fakeClock{now: time.Now()})
+
+		require.NoError(t, w.Write(ctx, []byte("partial")))
+		require.NoError(t, w.Fail(ctx, "stopped"))
+		require.Equal(t, "partial\n\nstopped", bot.texts[1])
+	})
+}
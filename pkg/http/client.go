@@ -6,7 +6,11 @@ import (
 )
 
 func NewHttpClient() *http.Client {
+	return NewHttpClientWithTimeout(10 * time.Second)
+}
+
+func NewHttpClientWithTimeout(timeout time.Duration) *http.Client {
 	return &http.Client{
-		Timeout: 10 * time.Second,
+		Timeout: timeout,
 	}
 }